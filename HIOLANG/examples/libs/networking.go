@@ -1,78 +1,5559 @@
 /*
  * HioClib Library Example - Networking in Go
  * HTTP and network utilities for Hiolang
+ *
+ * Needs golang.org/x/text besides the standard library, for charset
+ * decoding.
+ *
+ * Errors are reported through HioLastError_c, which returns the message
+ * left by the most recent call made on the calling thread (an empty
+ * string when that call succeeded). HioLastErrorCode_c gives the same
+ * error's category as a number scripts can branch on. Every function
+ * resets the error except HioFree_c and these two.
+ *
+ * Ownership: every non-NULL char* returned by this library is allocated
+ * with malloc and belongs to the caller, who must pass it to HioFree_c
+ * exactly once.
+ *
+ * Concurrency: every function may be called from any thread, and handles
+ * may be shared between threads. Client settings (HioClientSet*) should
+ * still be made before the client sends its first request.
+ *
+ * Handles: ids are never reused. Passing a freed, unknown or wrong-kind
+ * handle to any function, Free and Close included, does nothing beyond
+ * reporting the mistake through HioLastError_c.
  */
 
 package main
 
+/*
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+static __thread char* hio_last_error = NULL;
+static __thread int hio_last_error_code = 0;
+
+static void hio_set_last_error(const char* msg, int code) {
+    free(hio_last_error);
+    hio_last_error = NULL;
+    hio_last_error_code = code;
+    if (msg != NULL && msg[0] != '\0') {
+        hio_last_error = strdup(msg);
+    }
+}
+
+static int hio_get_last_error_code(void) {
+    return hio_last_error_code;
+}
+
+static const char* hio_get_last_error(void) {
+    return hio_last_error != NULL ? hio_last_error : "";
+}
+
+// Go cannot call a C function pointer directly, so route callbacks
+// through these.
+typedef char* (*hio_handler)(const char* body);
+typedef void (*hio_timer_callback)(long long timer);
+typedef void (*hio_progress_callback)(long long done, long long total);
+typedef void (*hio_signal_callback)(int sig);
+
+static char* hio_call_handler(uintptr_t fn, const char* body) {
+    return ((hio_handler)fn)(body);
+}
+
+static void hio_call_timer(uintptr_t fn, long long timer) {
+    ((hio_timer_callback)fn)(timer);
+}
+
+static void hio_call_progress(uintptr_t fn, long long done, long long total) {
+    ((hio_progress_callback)fn)(done, total);
+}
+
+static void hio_call_signal(uintptr_t fn, int sig) {
+    ((hio_signal_callback)fn)(sig);
+}
+*/
 import "C"
 import (
+    "bufio"
     "bytes"
+    "compress/gzip"
+    "context"
+    "crypto/hmac"
+    "crypto/md5"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
     "io/ioutil"
+    "math"
+    mathrand "math/rand"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net"
     "net/http"
+    "net/http/cookiejar"
+    "net/mail"
+    neturl "net/url"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
     "time"
+    "unsafe"
+
+    "golang.org/x/text/encoding/htmlindex"
+    "golang.org/x/text/encoding/ianaindex"
+    "golang.org/x/text/encoding/unicode"
+    "golang.org/x/text/transform"
+)
+
+const (
+    hioErrFailed    = -1
+    hioErrTimeout   = -2
+    hioErrStatus    = -3
+    hioErrFile      = -4
+    hioErrClosed    = -5
+    hioErrRange     = -6
+    hioErrCancelled = -7
 )
 
 //-------------------------
 //-------------------------
 
-//export HioHttpGet_c
-func HioHttpGet_c(url *C.char) *C.char {
-    goUrl := C.GoString(url)
+// setLastError records err (or clears the slot when err is nil) for the
+// calling thread. cgo runs an exported function on the thread that called
+// it, so this must only be used from the goroutine serving that call.
+func setLastError(err error) {
+    if err == nil {
+        C.hio_set_last_error(nil, hioCodeNone)
+        return
+    }
+    msg := C.CString(err.Error())
+    defer C.free(unsafe.Pointer(msg))
+    C.hio_set_last_error(msg, classifyError(err))
+}
+
+// Categories reported by HioLastErrorCode_c. The values are part of the
+// library's interface and must not be renumbered.
+const (
+    hioCodeNone          = 0
+    hioCodeTimeout       = 1
+    hioCodeDNS           = 2
+    hioCodeRefused       = 3
+    hioCodeTLS           = 4
+    hioCodeRedirects     = 5
+    hioCodeTooLarge      = 6
+    hioCodeInvalidHandle = 7
+    hioCodeOther         = 8
+)
+
+// classifyError picks the category of err for HioLastErrorCode_c. Checks
+// run from the most to the least specific, so a DNS lookup that timed out
+// counts as a timeout.
+func classifyError(err error) C.int {
+    var netErr net.Error
+    var dnsErr *net.DNSError
+    var handleErr *invalidHandleError
+    switch {
+    case errors.As(err, &netErr) && netErr.Timeout(),
+        errors.Is(err, context.DeadlineExceeded),
+        errors.Is(err, os.ErrDeadlineExceeded):
+        return hioCodeTimeout
+    case errors.As(err, &dnsErr):
+        return hioCodeDNS
+    case isConnRefused(err):
+        return hioCodeRefused
+    case isTLSError(err):
+        return hioCodeTLS
+    case errors.Is(err, errTooManyRedirects):
+        return hioCodeRedirects
+    case errors.Is(err, errResponseTooLarge):
+        return hioCodeTooLarge
+    case errors.As(err, &handleErr):
+        return hioCodeInvalidHandle
+    }
+    return hioCodeOther
+}
+
+// isTLSError reports whether err came from the TLS handshake or from
+// verifying the peer's certificate.
+func isTLSError(err error) bool {
+    var unknownAuthority x509.UnknownAuthorityError
+    var hostname x509.HostnameError
+    var invalid x509.CertificateInvalidError
+    var record tls.RecordHeaderError
+    var opErr *net.OpError
+    switch {
+    case errors.As(err, &unknownAuthority),
+        errors.As(err, &hostname),
+        errors.As(err, &invalid),
+        errors.As(err, &record):
+        return true
+    case errors.As(err, &opErr):
+        // crypto/tls reports alerts sent by the peer this way.
+        return opErr.Op == "remote error"
+    }
+    return false
+}
+
+func isTimeout(err error) bool {
+    netErr, ok := err.(net.Error)
+    return ok && netErr.Timeout()
+}
+
+// userAgent is the User-Agent sent by every request that does not set its
+// own. Empty leaves Go's default in place.
+var userAgent = struct {
+    sync.Mutex
+    value string
+}{}
+
+func currentUserAgent() string {
+    userAgent.Lock()
+    defer userAgent.Unlock()
+    return userAgent.value
+}
+
+// userAgentTransport adds the configured User-Agent to requests passing
+// through base. Every client in this library sends through one.
+type userAgentTransport struct {
+    base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    if ua := currentUserAgent(); ua != "" {
+        if _, ok := req.Header["User-Agent"]; !ok {
+            req = req.Clone(req.Context())
+            req.Header.Set("User-Agent", ua)
+        }
+    }
+    return t.base.RoundTrip(req)
+}
+
+// debugCapture holds the dump of the last request sent while capture is
+// on (see HioSetDebugCapture_c).
+var debugCapture = struct {
+    sync.Mutex
+    enabled bool
+    dump    string
+}{}
+
+// maxCapturedBody bounds how much of a request body a dump shows.
+const maxCapturedBody = 64 << 10
+
+// captureTransport records requests passing through base while debug
+// capture is on. It sits below userAgentTransport, so dumps show the
+// User-Agent that was actually sent.
+type captureTransport struct {
+    base http.RoundTripper
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    debugCapture.Lock()
+    enabled := debugCapture.enabled
+    debugCapture.Unlock()
+    if enabled {
+        dump := dumpRequest(req)
+        debugCapture.Lock()
+        debugCapture.dump = dump
+        debugCapture.Unlock()
+    }
+    return t.base.RoundTrip(req)
+}
+
+// dumpRequest renders req in the style of curl -v, with credentials
+// redacted. The body is read through GetBody so the request keeps its own.
+func dumpRequest(req *http.Request) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "> %s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+    fmt.Fprintf(&b, "> Host: %s\n", req.URL.Host)
+
+    keys := make([]string, 0, len(req.Header))
+    for key := range req.Header {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        for _, value := range req.Header[key] {
+            if key == "Authorization" || key == "Proxy-Authorization" {
+                value = "[redacted]"
+            }
+            fmt.Fprintf(&b, "> %s: %s\n", key, value)
+        }
+    }
+    if req.ContentLength > 0 && req.Header.Get("Content-Length") == "" {
+        fmt.Fprintf(&b, "> Content-Length: %d\n", req.ContentLength)
+    }
+    b.WriteString(">\n")
+
+    switch {
+    case req.Body == nil || req.Body == http.NoBody:
+    case req.GetBody == nil:
+        b.WriteString("[streamed body not captured]\n")
+    default:
+        body, err := req.GetBody()
+        if err != nil {
+            fmt.Fprintf(&b, "[body not captured: %v]\n", err)
+            break
+        }
+        data, _ := ioutil.ReadAll(io.LimitReader(body, maxCapturedBody+1))
+        body.Close()
+        if len(data) > maxCapturedBody {
+            b.Write(data[:maxCapturedBody])
+            fmt.Fprintf(&b, "\n[body cut at %d bytes]\n", maxCapturedBody)
+        } else {
+            b.Write(data)
+        }
+    }
+    return b.String()
+}
+
+var sharedClient = &http.Client{
+    Timeout:       time.Second * 10,
+    Transport:     &userAgentTransport{&captureTransport{http.DefaultTransport}},
+    CheckRedirect: followRedirects,
+}
+
+// millis converts a millisecond count from Hiolang, treating negative
+// values as zero.
+func millis(ms C.longlong) time.Duration {
+    if ms < 0 {
+        return 0
+    }
+    return time.Duration(ms) * time.Millisecond
+}
+
+// clientWithTimeout returns a client that shares the default transport's
+// connection pool but bounds the whole request, body read included, by
+// timeoutMs. Zero means no timeout.
+func clientWithTimeout(timeoutMs C.longlong) *http.Client {
+    return &http.Client{
+        Timeout:       millis(timeoutMs),
+        Transport:     sharedClient.Transport,
+        CheckRedirect: followRedirects,
+    }
+}
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+// followRedirects is Go's default policy of following up to ten redirects,
+// except that hitting the limit is reported as errTooManyRedirects.
+func followRedirects(req *http.Request, via []*http.Request) error {
+    if len(via) >= 10 {
+        return fmt.Errorf("stopped after 10 redirects: %w", errTooManyRedirects)
+    }
+    return nil
+}
+
+// clientWithRedirectLimit returns a copy of the shared client that follows
+// at most max redirects. A negative max stops at the first redirect and
+// hands back that response instead of following it.
+func clientWithRedirectLimit(max int) *http.Client {
+    c := *sharedClient
+    c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+        if max < 0 {
+            return http.ErrUseLastResponse
+        }
+        if len(via) > max {
+            return fmt.Errorf("stopped after %d redirects: %w", max, errTooManyRedirects)
+        }
+        return nil
+    }
+    return &c
+}
+
+// traceRedirects sends req and lists every hop it took as "status url",
+// one per line, ending with the final response.
+func traceRedirects(req *http.Request) (string, error) {
+    var hops []string
+    c := *sharedClient
+    c.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+        hops = append(hops, fmt.Sprintf("%d %s", next.Response.StatusCode, via[len(via)-1].URL))
+        return followRedirects(next, via)
+    }
+    resp, err := c.Do(req)
+    if err != nil {
+        return "", err
+    }
+    resp.Body.Close()
+    hops = append(hops, fmt.Sprintf("%d %s", resp.StatusCode, resp.Request.URL))
+    return strings.Join(hops, "\n"), nil
+}
 
-    client := &http.Client{
-        Timeout: time.Second * 10,
+// newRequest builds a request whose body is omitted entirely when the
+// given string is empty.
+func newRequest(method string, url string, body string) (*http.Request, error) {
+    var reader io.Reader
+    if body != "" {
+        reader = strings.NewReader(body)
     }
+    return http.NewRequest(method, url, reader)
+}
 
-    resp, err := client.Get(goUrl)
+// newPostRequest builds a POST carrying data, defaulting an empty content
+// type to application/octet-stream.
+func newPostRequest(url string, data string, contentType string) (*http.Request, error) {
+    req, err := http.NewRequest("POST", url, bytes.NewBufferString(data))
     if err != nil {
-        return C.CString(err.Error())
+        return nil, err
+    }
+    if contentType == "" {
+        contentType = "application/octet-stream"
     }
+    req.Header.Set("Content-Type", contentType)
+    return req, nil
+}
+
+// maxResponseBytes caps how much of a body readBody buffers. Zero means
+// no limit.
+var maxResponseBytes = struct {
+    sync.Mutex
+    value int64
+}{}
+
+var errResponseTooLarge = errors.New("response too large")
+
+func responseLimit() int64 {
+    maxResponseBytes.Lock()
+    defer maxResponseBytes.Unlock()
+    return maxResponseBytes.value
+}
+
+// readBody buffers the body of resp. One larger than maxResponseBytes is
+// cut at the limit and returned along with an error wrapping
+// errResponseTooLarge.
+func readBody(resp *http.Response) ([]byte, error) {
     defer resp.Body.Close()
 
-    body, err := ioutil.ReadAll(resp.Body)
+    limit := responseLimit()
+    if limit <= 0 {
+        return ioutil.ReadAll(resp.Body)
+    }
+
+    body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
     if err != nil {
-        return C.CString(err.Error())
+        return nil, err
     }
+    if int64(len(body)) > limit {
+        return body[:limit], fmt.Errorf("%w: body exceeds %d bytes", errResponseTooLarge, limit)
+    }
+    return body, nil
+}
 
-    return C.CString(string(body))
+// gunzip decompresses data. With a positive limit, output beyond it is
+// cut off and reported as errResponseTooLarge, as readBody does, so a
+// small compressed body cannot expand without bound.
+func gunzip(data []byte, limit int64) ([]byte, error) {
+    reader, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+    if limit <= 0 {
+        return ioutil.ReadAll(reader)
+    }
+    decoded, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+    if int64(len(decoded)) > limit {
+        return decoded[:limit], fmt.Errorf("%w: decompressed body exceeds %d bytes", errResponseTooLarge, limit)
+    }
+    return decoded, err
 }
 
-//export HioHttpPost_c
-func HioHttpPost_c(url *C.char, data *C.char) *C.char {
-    goUrl := C.GoString(url)
-    goData := C.GoString(data)
+func gzipBytes(data []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := gzip.NewWriter(&buf)
+    if _, err := writer.Write(data); err != nil {
+        return nil, err
+    }
+    if err := writer.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
 
-    client := &http.Client{
-        Timeout: time.Second * 10,
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)`)
+
+// bodyCharset works out the charset of a body: from the Content-Type
+// parameter, then a byte order mark, then for HTML a <meta> declaration
+// near the top, falling back to UTF-8.
+func bodyCharset(contentType string, body []byte) string {
+    mediaType, params, _ := mime.ParseMediaType(contentType)
+    if charset := params["charset"]; charset != "" {
+        return strings.ToLower(charset)
+    }
+    switch {
+    case bytes.HasPrefix(body, []byte{0xEF, 0xBB, 0xBF}):
+        return "utf-8"
+    case bytes.HasPrefix(body, []byte{0xFF, 0xFE}):
+        return "utf-16le"
+    case bytes.HasPrefix(body, []byte{0xFE, 0xFF}):
+        return "utf-16be"
     }
+    if mediaType == "text/html" {
+        head := body
+        if len(head) > 1024 {
+            head = head[:1024]
+        }
+        if m := metaCharset.FindSubmatch(head); m != nil {
+            return strings.ToLower(string(m[1]))
+        }
+    }
+    return "utf-8"
+}
 
-    resp, err := client.Post(
-        goUrl,
-        "application/json",
-        bytes.NewBufferString(goData),
-    )
+// decodeText transcodes body from charset to UTF-8. Labels resolve as in
+// browsers, through the WHATWG Encoding Standard, so Latin-1 is read as
+// windows-1252, its superset. Labels it leaves out, e.g. IBM code pages,
+// are looked up in the IANA registry. A byte order mark overrides the
+// label. An unsupported charset returns the body unchanged along with an
+// error.
+func decodeText(body []byte, charset string) (string, error) {
+    enc, err := htmlindex.Get(charset)
     if err != nil {
-        return C.CString(err.Error())
+        enc, err = ianaindex.IANA.Encoding(charset)
     }
-    defer resp.Body.Close()
+    // The IANA index knows some charsets it cannot decode.
+    if err != nil || enc == nil {
+        return string(body), fmt.Errorf("unsupported charset %q", charset)
+    }
+    decoded, _, err := transform.Bytes(unicode.BOMOverride(enc.NewDecoder()), body)
+    if err != nil {
+        return string(body), err
+    }
+    return string(decoded), nil
+}
+
+// response is the buffered result of a request, as kept behind a
+// response handle.
+type response struct {
+    status int
+    header http.Header
+    body   []byte
+
+    // length is the declared Content-Length, or -1 when none was sent.
+    length int64
+
+    // stream reads the body incrementally. A streamed response leaves body
+    // empty and keeps the connection open until closer is closed.
+    stream *bufio.Reader
+    closer io.Closer
+    readMu sync.Mutex
 
-    body, err := ioutil.ReadAll(resp.Body)
+    // elapsed is the time until the response headers arrived; total also
+    // covers reading the body, and is -1 for a streamed response.
+    elapsed time.Duration
+    total   time.Duration
+
+    // tlsState describes the connection for HTTPS responses, nil otherwise.
+    tlsState *tls.ConnectionState
+
+    // fromCache is set when a client's response cache answered the request.
+    fromCache bool
+}
+
+// cacheHitKey keys the *bool that a request context carries for
+// cachingTransport to report through whether the cache answered it.
+type cacheHitKey struct{}
+
+// trackCacheHit returns req with a flag attached that tells, once the
+// request has been sent, whether its final hop was served from cache.
+func trackCacheHit(req *http.Request) (*http.Request, *bool) {
+    hit := new(bool)
+    return req.WithContext(context.WithValue(req.Context(), cacheHitKey{}, hit)), hit
+}
+
+// reader returns the reader for incremental reads, which for a buffered
+// response walks over body. The caller must hold readMu.
+func (r *response) reader() *bufio.Reader {
+    if r.stream == nil {
+        r.stream = bufio.NewReader(bytes.NewReader(r.body))
+    }
+    return r.stream
+}
+
+// openResponse sends req and returns a response whose body is left unread.
+func openResponse(client *http.Client, req *http.Request) (*response, error) {
+    req, fromCache := trackCacheHit(req)
+    start := time.Now()
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    return &response{
+        status:    resp.StatusCode,
+        header:    resp.Header,
+        length:    resp.ContentLength,
+        stream:    bufio.NewReader(resp.Body),
+        closer:    resp.Body,
+        elapsed:   time.Since(start),
+        total:     -1,
+        tlsState:  resp.TLS,
+        fromCache: *fromCache,
+    }, nil
+}
+
+func fetchResponse(client *http.Client, req *http.Request) (*response, error) {
+    req, fromCache := trackCacheHit(req)
+    start := time.Now()
+    resp, err := client.Do(req)
     if err != nil {
-        return C.CString(err.Error())
+        return nil, err
+    }
+    elapsed := time.Since(start)
+    body, err := readBody(resp)
+    if err != nil && !errors.Is(err, errResponseTooLarge) {
+        return nil, err
+    }
+    return &response{
+        status:    resp.StatusCode,
+        header:    resp.Header,
+        body:      body,
+        length:    resp.ContentLength,
+        elapsed:   elapsed,
+        total:     time.Since(start),
+        tlsState:  resp.TLS,
+        fromCache: *fromCache,
+    }, err
+}
+
+// fetchBody returns the body of the response to req. A body cut short by
+// maxResponseBytes is returned together with the error.
+func fetchBody(client *http.Client, req *http.Request) ([]byte, error) {
+    resp, err := fetchResponse(client, req)
+    if resp == nil {
+        return nil, err
+    }
+    return resp.body, err
+}
+
+// fetchWithRetry repeats req on connection errors and on 5xx and 429
+// responses, up to attempts times in total, and reports how many attempts
+// it made. When it gives up, the last response (if any) is returned along
+// with an error naming that number.
+func fetchWithRetry(client *http.Client, req *http.Request, attempts int, baseDelay time.Duration) (*response, int, error) {
+    for attempt := 1; ; attempt++ {
+        resp, err := fetchResponse(client, req)
+        if errors.Is(err, errResponseTooLarge) {
+            return resp, attempt, err
+        }
+        if err == nil && resp.status < 500 && resp.status != http.StatusTooManyRequests {
+            return resp, attempt, nil
+        }
+        if attempt >= attempts {
+            if err == nil {
+                err = fmt.Errorf("unexpected status %d", resp.status)
+            }
+            return resp, attempt, fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+        }
+        time.Sleep(retryDelay(resp, attempt, baseDelay))
+    }
+}
+
+// fetchBatch GETs every url with at most concurrency requests in flight and
+// returns the bodies in input order. A failed url leaves an empty slot and
+// contributes one line to the returned error.
+func fetchBatch(urls []string, concurrency int) ([]string, error) {
+    if concurrency < 1 {
+        concurrency = 1
+    }
+    bodies := make([]string, len(urls))
+    failures := make(chan string, len(urls))
+    sem := make(chan struct{}, concurrency)
+    var wg sync.WaitGroup
+
+    for i, url := range urls {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(i int, url string) {
+            defer wg.Done()
+            defer func() { <-sem }()
+
+            req, err := http.NewRequest("GET", url, nil)
+            if err != nil {
+                failures <- fmt.Sprintf("%s: %v", url, err)
+                return
+            }
+            body, err := fetchBody(sharedClient, req)
+            if err != nil {
+                failures <- fmt.Sprintf("%s: %v", url, err)
+                return
+            }
+            bodies[i] = string(body)
+        }(i, url)
+    }
+    wg.Wait()
+    close(failures)
+
+    var messages []string
+    for msg := range failures {
+        messages = append(messages, msg)
+    }
+    if len(messages) > 0 {
+        return bodies, fmt.Errorf("%d of %d requests failed:\n%s", len(messages), len(urls), strings.Join(messages, "\n"))
+    }
+    return bodies, nil
+}
+
+const maxRetryDelay = time.Minute
+
+// retryDelay doubles baseDelay with every attempt, up to maxRetryDelay,
+// and picks a random point in the upper half of that window, unless the
+// server asked for a specific wait with Retry-After. Either way the wait
+// is at most maxRetryDelay.
+func retryDelay(resp *response, attempt int, baseDelay time.Duration) time.Duration {
+    if resp != nil {
+        if after := resp.header.Get("Retry-After"); after != "" {
+            if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+                if seconds > int(maxRetryDelay/time.Second) {
+                    return maxRetryDelay
+                }
+                return time.Duration(seconds) * time.Second
+            }
+            if when, err := http.ParseTime(after); err == nil {
+                if wait := time.Until(when); wait < maxRetryDelay {
+                    return wait
+                }
+                return maxRetryDelay
+            }
+        }
+    }
+
+    if baseDelay <= 0 {
+        return 0
     }
+    delay := baseDelay << uint(attempt-1)
+    if attempt > 32 || delay <= 0 || delay > maxRetryDelay {
+        delay = maxRetryDelay
+    }
+    half := int64(delay / 2)
+    return time.Duration(half + mathrand.Int63n(half+1))
+}
 
+// bodyResult converts a body/error pair into the string handed back to
+// Hiolang: the body on success, an empty string on failure.
+func bodyResult(body []byte, err error) *C.char {
+    setLastError(err)
     return C.CString(string(body))
 }
 
-//export HioGetTimestamp_c
-func HioGetTimestamp_c() C.longlong {
-    return C.longlong(time.Now().Unix())
+func stringResult(s string, err error) *C.char {
+    setLastError(err)
+    return C.CString(s)
 }
 
-//export HioSleep_c
-func HioSleep_c(ms C.longlong) {
-    time.Sleep(time.Duration(ms) * time.Millisecond)
+// goBytes copies length bytes from data, which may contain NUL bytes.
+func goBytes(data *C.char, length C.int) []byte {
+    if data == nil || length <= 0 {
+        return nil
+    }
+    return C.GoBytes(unsafe.Pointer(data), length)
+}
+
+// bytesResult is bodyResult for binary data: the copy is still
+// NUL-terminated, but its real length is written to outLen when non-NULL.
+func bytesResult(b []byte, outLen *C.int, err error) *C.char {
+    setLastError(err)
+    if outLen != nil {
+        *outLen = C.int(len(b))
+    }
+    return C.CString(string(b))
+}
+
+// boolResult maps b onto the 1/0 answers of the Hio*Is* predicates.
+func boolResult(b bool) C.int {
+    if b {
+        return 1
+    }
+    return 0
+}
+
+//-------------------------
+//-------------------------
+
+// handles maps the opaque ids given out to Hiolang onto live Go values.
+// Ids count up from 1 and are never reused, so a stale id can only ever
+// miss, never reach a value stored after it was freed.
+var handles = struct {
+    sync.RWMutex
+    next   int64
+    values map[int64]interface{}
+}{values: make(map[int64]interface{})}
+
+func storeHandle(value interface{}) C.longlong {
+    handles.Lock()
+    defer handles.Unlock()
+    handles.next++
+    handles.values[handles.next] = value
+    return C.longlong(handles.next)
+}
+
+func loadHandle(handle C.longlong) interface{} {
+    handles.RLock()
+    defer handles.RUnlock()
+    return handles.values[int64(handle)]
+}
+
+// invalidHandleError is returned for a handle that does not name a live
+// value of the kind a function expects.
+type invalidHandleError struct {
+    kind   string
+    handle int64
+    freed  bool
+}
+
+func (e *invalidHandleError) Error() string {
+    if e.freed {
+        return fmt.Sprintf("%s handle %d has already been freed", e.kind, e.handle)
+    }
+    return fmt.Sprintf("invalid %s handle %d", e.kind, e.handle)
+}
+
+// handleError explains why handle does not name a live value of the given
+// kind, telling ids that were freed apart from ones never given out.
+func handleError(kind string, handle C.longlong) error {
+    handles.RLock()
+    defer handles.RUnlock()
+    _, live := handles.values[int64(handle)]
+    freed := !live && handle > 0 && int64(handle) <= handles.next
+    return &invalidHandleError{kind: kind, handle: int64(handle), freed: freed}
+}
+
+func releaseHandle(handle C.longlong) interface{} {
+    handles.Lock()
+    defer handles.Unlock()
+    value := handles.values[int64(handle)]
+    delete(handles.values, int64(handle))
+    return value
+}
+
+// freeHandle releases a handle that has just been looked up as kind and
+// sets the last error. When several threads free it at once only the
+// first gets the value back; the others are told it was already freed.
+func freeHandle(kind string, handle C.longlong) interface{} {
+    value := releaseHandle(handle)
+    if value == nil {
+        setLastError(handleError(kind, handle))
+    } else {
+        setLastError(nil)
+    }
+    return value
+}
+
+// editValue and viewValue guard the values Hiolang fills in piece by
+// piece (header sets, queries and JSON objects): changes run under the
+// registry's write lock and reads under its read lock, so one thread can
+// add to a handle while another sends a request built from it.
+func editValue(fn func()) {
+    handles.Lock()
+    defer handles.Unlock()
+    fn()
+}
+
+func viewValue(fn func()) {
+    handles.RLock()
+    defer handles.RUnlock()
+    fn()
+}
+
+// releaseAll empties the registry and returns everything that was in it.
+func releaseAll() []interface{} {
+    handles.Lock()
+    defer handles.Unlock()
+    values := make([]interface{}, 0, len(handles.values))
+    for _, value := range handles.values {
+        values = append(values, value)
+    }
+    handles.values = make(map[int64]interface{})
+    return values
+}
+
+// countHandles returns how many registered values satisfy match.
+func countHandles(match func(interface{}) bool) int {
+    handles.RLock()
+    defer handles.RUnlock()
+    n := 0
+    for _, value := range handles.values {
+        if match(value) {
+            n++
+        }
+    }
+    return n
+}
+
+// isConn reports whether value holds a socket: a TCP, UDP, WebSocket or
+// SSE connection, or a listener or server.
+func isConn(value interface{}) bool {
+    switch value.(type) {
+    case *streamConn, *packetConn, *wsConn, *sseStream, *net.TCPListener, *httpServer:
+        return true
+    }
+    return false
+}
+
+// closeValue releases whatever resources a registered value holds on to.
+func closeValue(value interface{}) {
+    switch v := value.(type) {
+    case *httpClient:
+        v.transport.CloseIdleConnections()
+    case *response:
+        if v.closer != nil {
+            v.closer.Close()
+        }
+    case *asyncRequest:
+        v.cancel()
+    case *cancelToken:
+        v.cancel()
+    case io.Closer:
+        v.Close()
+    }
+}
+
+func lookupHeader(handle C.longlong) (http.Header, error) {
+    header, ok := loadHandle(handle).(http.Header)
+    if !ok {
+        return nil, handleError("header", handle)
+    }
+    return header, nil
+}
+
+// applyHeaders copies header onto req, replacing any default the request
+// already carries for the same key.
+func applyHeaders(req *http.Request, header http.Header) {
+    for key := range header {
+        req.Header.Del(key)
+    }
+    for key, values := range header {
+        for _, value := range values {
+            req.Header.Add(key, value)
+        }
+    }
+}
+
+// fetchWithHeaders sends req with the headers collected under handle.
+func fetchWithHeaders(req *http.Request, handle C.longlong) *C.char {
+    header, err := lookupHeader(handle)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    viewValue(func() { applyHeaders(req, header) })
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+func lookupResponse(handle C.longlong) (*response, error) {
+    resp, ok := loadHandle(handle).(*response)
+    if !ok {
+        return nil, handleError("response", handle)
+    }
+    return resp, nil
+}
+
+// responseResult stores a fetched response and returns its handle, or 0
+// when the request failed.
+func responseResult(resp *response, err error) C.longlong {
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(resp)
+}
+
+var tlsVersions = map[uint16]string{
+    tls.VersionTLS10: "TLS 1.0",
+    tls.VersionTLS11: "TLS 1.1",
+    tls.VersionTLS12: "TLS 1.2",
+    tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsPart returns what part reads from the TLS state of the response
+// behind handle, or an empty string for a plain-HTTP response.
+func tlsPart(handle C.longlong, part func(*tls.ConnectionState) string) *C.char {
+    resp, err := lookupResponse(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    if resp.tlsState == nil {
+        return stringResult("", nil)
+    }
+    return stringResult(part(resp.tlsState), nil)
+}
+
+// httpClient is a reusable HTTP client with a connection pool of its own.
+type httpClient struct {
+    http      *http.Client
+    transport *http.Transport
+    limiter   *rateLimiter
+    cache     *responseCache
+
+    // The dialer is rebuilt from both whenever either is set.
+    connectTimeout time.Duration
+    socketPath     string
+}
+
+func newClient(timeoutMs C.longlong) *httpClient {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    limiter := &rateLimiter{}
+    cache := &responseCache{}
+    chain := &userAgentTransport{&captureTransport{&limitedTransport{limiter, transport}}}
+    return &httpClient{
+        http: &http.Client{
+            Timeout:       millis(timeoutMs),
+            Transport:     &cachingTransport{cache, chain},
+            CheckRedirect: followRedirects,
+        },
+        transport:      transport,
+        limiter:        limiter,
+        cache:          cache,
+        connectTimeout: 30 * time.Second, // http.DefaultTransport's
+    }
+}
+
+// setDialer points the transport at a dialer built from the connect
+// timeout and, when one is set, the Unix socket path.
+func (c *httpClient) setDialer() {
+    dialer := &net.Dialer{Timeout: c.connectTimeout, KeepAlive: 30 * time.Second}
+    if c.socketPath == "" {
+        c.transport.DialContext = dialer.DialContext
+        return
+    }
+    path := c.socketPath
+    c.transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+        return dialer.DialContext(ctx, "unix", path)
+    }
+    c.transport.Proxy = nil
+}
+
+// rateLimiter is a token bucket holding up to burst tokens and refilled
+// at rate tokens per second. A rate of 0 lets everything through.
+type rateLimiter struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func (l *rateLimiter) set(rate float64, burst int) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if burst < 1 {
+        burst = 1
+    }
+    l.rate = rate
+    l.burst = float64(burst)
+    l.tokens = l.burst
+    l.last = time.Now()
+}
+
+// wait takes a token, sleeping until one is due if the bucket is empty.
+// It gives the token back and returns early when ctx ends first.
+func (l *rateLimiter) wait(ctx context.Context) error {
+    return l.waitN(ctx, 1)
+}
+
+// waitN is wait for n tokens at once. The bucket may go into debt, so n
+// can exceed burst.
+func (l *rateLimiter) waitN(ctx context.Context, n float64) error {
+    l.mu.Lock()
+    if l.rate <= 0 {
+        l.mu.Unlock()
+        return nil
+    }
+    now := time.Now()
+    l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+    l.last = now
+    l.tokens -= n
+    delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+    l.mu.Unlock()
+    if delay <= 0 {
+        return nil
+    }
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        l.mu.Lock()
+        l.tokens += n
+        l.mu.Unlock()
+        return ctx.Err()
+    }
+}
+
+const (
+    progressBytes    = 1 << 20
+    progressInterval = time.Millisecond * 100
+)
+
+// progressReader counts the bytes read from r and passes the count to a
+// C callback every progressBytes or progressInterval, and at the end.
+type progressReader struct {
+    r        io.Reader
+    total    int64
+    callback C.uintptr_t
+
+    done     int64
+    reported int64
+    last     time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+    n, err := p.r.Read(b)
+    p.done += int64(n)
+    due := p.done-p.reported >= progressBytes || (p.done > p.reported && time.Since(p.last) >= progressInterval)
+    if due || err == io.EOF {
+        C.hio_call_progress(p.callback, C.longlong(p.done), C.longlong(p.total))
+        p.reported = p.done
+        p.last = time.Now()
+    }
+    return n, err
+}
+
+// throttledReader holds reads from r to limiter's rate, one token per
+// byte. Reads are cut to the burst size, so that each one is paid for
+// shortly after it arrives rather than in large lumps.
+type throttledReader struct {
+    r       io.Reader
+    ctx     context.Context
+    limiter *rateLimiter
+}
+
+func newThrottledReader(ctx context.Context, r io.Reader, bytesPerSec int64) *throttledReader {
+    limiter := &rateLimiter{}
+    // A tenth of a second's worth keeps the pace even.
+    limiter.set(float64(bytesPerSec), int(bytesPerSec/10))
+    return &throttledReader{r: r, ctx: ctx, limiter: limiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+    if burst := int(t.limiter.burst); len(p) > burst {
+        p = p[:burst]
+    }
+    n, err := t.r.Read(p)
+    if n > 0 {
+        if waitErr := t.limiter.waitN(t.ctx, float64(n)); waitErr != nil {
+            return n, waitErr
+        }
+    }
+    return n, err
+}
+
+// limitedTransport holds every request back until limiter allows it.
+type limitedTransport struct {
+    limiter *rateLimiter
+    base    http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    if err := t.limiter.wait(req.Context()); err != nil {
+        return nil, err
+    }
+    return t.base.RoundTrip(req)
+}
+
+// responseCache keeps the bodies of successful GET and HEAD responses,
+// keyed by method and URL, for ttl. It holds at most maxEntries, dropping
+// the oldest to make room; a maxEntries of 0 turns it off.
+type responseCache struct {
+    mu         sync.Mutex
+    maxEntries int
+    ttl        time.Duration
+    entries    map[string]*cachedResponse
+}
+
+type cachedResponse struct {
+    status  int
+    proto   string
+    header  http.Header
+    body    []byte
+    length  int64
+    expires time.Time
+}
+
+func (c *responseCache) configure(maxEntries int, ttl time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.maxEntries = maxEntries
+    c.ttl = ttl
+    c.entries = make(map[string]*cachedResponse)
+}
+
+func (c *responseCache) clear() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.entries = make(map[string]*cachedResponse)
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    entry := c.entries[key]
+    if entry != nil && time.Now().After(entry.expires) {
+        delete(c.entries, key)
+        return nil
+    }
+    return entry
+}
+
+func (c *responseCache) put(key string, entry *cachedResponse) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.maxEntries <= 0 {
+        return
+    }
+    entry.expires = time.Now().Add(c.ttl)
+    if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+        var oldest string
+        for k, e := range c.entries {
+            if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+                oldest = k
+            }
+        }
+        delete(c.entries, oldest)
+    }
+    c.entries[key] = entry
+}
+
+func (c *responseCache) enabled() bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.maxEntries > 0
+}
+
+// cachingTransport answers repeated GET and HEAD requests from cache and
+// fills it from base. It sits in front of the rest of the chain, so a hit
+// neither waits for the rate limiter nor shows up in a debug capture.
+type cachingTransport struct {
+    cache *responseCache
+    base  http.RoundTripper
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    // Every hop resets the flag, so a cached redirect target followed by a
+    // network fetch still reports the final response correctly.
+    hit, _ := req.Context().Value(cacheHitKey{}).(*bool)
+    if hit == nil {
+        hit = new(bool)
+    }
+    *hit = false
+    if !t.cache.enabled() || (req.Method != "GET" && req.Method != "HEAD") || noStore(req.Header) || personal(req.Header) {
+        return t.base.RoundTrip(req)
+    }
+    key := req.Method + " " + req.URL.String()
+    if entry := t.cache.get(key); entry != nil {
+        *hit = true
+        return entry.response(req), nil
+    }
+
+    resp, err := t.base.RoundTrip(req)
+    if err != nil || resp.StatusCode != http.StatusOK || noStore(resp.Header) {
+        return resp, err
+    }
+    // Buffer no more than readBody would; a longer body is passed on
+    // uncached, its first part stitched back in front of the rest.
+    reader := io.Reader(resp.Body)
+    limit := responseLimit()
+    if limit > 0 {
+        reader = io.LimitReader(resp.Body, limit+1)
+    }
+    body, err := ioutil.ReadAll(reader)
+    if err != nil {
+        resp.Body.Close()
+        return nil, err
+    }
+    if limit > 0 && int64(len(body)) > limit {
+        resp.Body = struct {
+            io.Reader
+            io.Closer
+        }{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+        return resp, nil
+    }
+    resp.Body.Close()
+    entry := &cachedResponse{status: resp.StatusCode, proto: resp.Proto, header: resp.Header, body: body, length: int64(len(body))}
+    if req.Method == "HEAD" {
+        entry.length = resp.ContentLength
+    }
+    t.cache.put(key, entry)
+    return entry.response(req), nil
+}
+
+// response builds a fresh response to req from the entry.
+func (e *cachedResponse) response(req *http.Request) *http.Response {
+    return &http.Response{
+        Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+        StatusCode:    e.status,
+        Proto:         e.proto,
+        Header:        e.header.Clone(),
+        Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+        ContentLength: e.length,
+        Request:       req,
+    }
+}
+
+// personal reports whether a request carries credentials, whose response
+// may differ from one user to the next and so must not be shared.
+func personal(header http.Header) bool {
+    return header.Get("Authorization") != "" || header.Get("Cookie") != ""
+}
+
+// noStore reports whether header carries Cache-Control: no-store.
+func noStore(header http.Header) bool {
+    for _, value := range header.Values("Cache-Control") {
+        for _, directive := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func lookupClient(handle C.longlong) (*httpClient, error) {
+    c, ok := loadHandle(handle).(*httpClient)
+    if !ok {
+        return nil, handleError("client", handle)
+    }
+    return c, nil
+}
+
+// clientFetch sends req through the client behind handle.
+func clientFetch(handle C.longlong, req *http.Request) *C.char {
+    c, err := lookupClient(handle)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(c.http, req))
+}
+
+// clientResponse is clientFetch for the functions that hand back a
+// response handle.
+func clientResponse(handle C.longlong, req *http.Request) C.longlong {
+    c, err := lookupClient(handle)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return responseResult(fetchResponse(c.http, req))
+}
+
+// configureClient applies fn to the transport of the client behind handle,
+// returning 0 on success and -1 for an unknown handle. Transport settings
+// should be changed before the client sends its first request.
+func configureClient(handle C.longlong, fn func(*http.Transport)) C.int {
+    c, err := lookupClient(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    fn(c.transport)
+    return 0
+}
+
+// tlsConfig returns the transport's TLS settings, creating them on first
+// use.
+func tlsConfig(t *http.Transport) *tls.Config {
+    if t.TLSClientConfig == nil {
+        t.TLSClientConfig = &tls.Config{}
+    }
+    return t.TLSClientConfig
+}
+
+// cancelToken is a context kept behind a context handle, so Hiolang can
+// abort the requests made with it.
+type cancelToken struct {
+    ctx    context.Context
+    cancel context.CancelFunc
+}
+
+func lookupContext(handle C.longlong) (*cancelToken, error) {
+    token, ok := loadHandle(handle).(*cancelToken)
+    if !ok {
+        return nil, handleError("context", handle)
+    }
+    return token, nil
+}
+
+// clientFetchCtx is clientFetch for a request bound to the context behind
+// ctx.
+func clientFetchCtx(handle C.longlong, req *http.Request, ctx C.longlong) *C.char {
+    token, err := lookupContext(ctx)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    return clientFetch(handle, req.WithContext(token.ctx))
+}
+
+// asyncRequest is a request running in the background behind an async
+// handle. body and err are only valid once done is closed.
+type asyncRequest struct {
+    cancel context.CancelFunc
+    done   chan struct{}
+    body   []byte
+    err    error
+}
+
+func startAsync(req *http.Request) *asyncRequest {
+    ctx, cancel := context.WithCancel(req.Context())
+    async := &asyncRequest{cancel: cancel, done: make(chan struct{})}
+    go func() {
+        defer close(async.done)
+        async.body, async.err = fetchBody(sharedClient, req.WithContext(ctx))
+    }()
+    return async
+}
+
+func lookupAsync(handle C.longlong) (*asyncRequest, error) {
+    async, ok := loadHandle(handle).(*asyncRequest)
+    if !ok {
+        return nil, handleError("async", handle)
+    }
+    return async, nil
+}
+
+// transferClient has no overall timeout, since streaming a large file to
+// or from disk can legitimately take a long time; only the wait for the
+// response headers is bounded.
+var transferClient = func() *httpClient {
+    c := newClient(0)
+    c.transport.ResponseHeaderTimeout = time.Second * 30
+    return c
+}()
+
+// errorCode maps a failed transfer onto the codes returned to Hiolang.
+func errorCode(err error) C.int {
+    if isTimeout(err) {
+        return hioErrTimeout
+    }
+    return hioErrFailed
+}
+
+// download streams the response to req into destPath, truncating any
+// existing file and removing a partially written one on failure. When wrap
+// is non-nil the body is read through the reader it returns.
+func download(req *http.Request, destPath string, wrap func(*http.Response) io.Reader) C.int {
+    resp, err := transferClient.http.Do(req)
+    if err != nil {
+        setLastError(err)
+        return errorCode(err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        setLastError(fmt.Errorf("unexpected status %s", resp.Status))
+        return hioErrStatus
+    }
+
+    file, err := os.Create(destPath)
+    if err != nil {
+        setLastError(err)
+        return hioErrFile
+    }
+    var body io.Reader = resp.Body
+    if wrap != nil {
+        body = wrap(resp)
+    }
+    _, err = io.Copy(file, body)
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(destPath)
+        setLastError(err)
+        return errorCode(err)
+    }
+
+    setLastError(nil)
+    return 0
+}
+
+// downloadRange writes the partial response to req into destPath at
+// offset start, leaving the rest of an existing file alone. The file is
+// kept on failure, so a later call can resume from its length.
+func downloadRange(req *http.Request, destPath string, start int64) C.int {
+    resp, err := transferClient.http.Do(req)
+    if err != nil {
+        setLastError(err)
+        return errorCode(err)
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == http.StatusOK:
+        setLastError(errors.New("server ignored the Range header and sent the whole body"))
+        return hioErrRange
+    case resp.StatusCode != http.StatusPartialContent:
+        setLastError(fmt.Errorf("unexpected status %s", resp.Status))
+        return hioErrStatus
+    }
+    var first, last int64
+    contentRange := resp.Header.Get("Content-Range")
+    if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/", &first, &last); err != nil || first != start {
+        setLastError(fmt.Errorf("unexpected Content-Range %q for offset %d", contentRange, start))
+        return hioErrRange
+    }
+
+    file, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE, 0644)
+    if err != nil {
+        setLastError(err)
+        return hioErrFile
+    }
+    _, err = file.Seek(start, io.SeekStart)
+    if err == nil {
+        _, err = io.Copy(file, resp.Body)
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    setLastError(err)
+    if err != nil {
+        return errorCode(err)
+    }
+    return 0
+}
+
+func lookupQuery(handle C.longlong) (neturl.Values, error) {
+    values, ok := loadHandle(handle).(neturl.Values)
+    if !ok {
+        return nil, handleError("query", handle)
+    }
+    return values, nil
+}
+
+func lookupURL(handle C.longlong) (*neturl.URL, error) {
+    u, ok := loadHandle(handle).(*neturl.URL)
+    if !ok {
+        return nil, handleError("URL", handle)
+    }
+    return u, nil
+}
+
+// urlPart returns the component of the parsed URL behind handle that part
+// selects.
+func urlPart(handle C.longlong, part func(*neturl.URL) string) *C.char {
+    u, err := lookupURL(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    return stringResult(part(u), nil)
+}
+
+// joinURL appends query to base with the right separator, keeping any
+// fragment on base at the end.
+func joinURL(base string, query string) string {
+    query = strings.TrimLeft(query, "?&")
+    if query == "" {
+        return base
+    }
+
+    fragment := ""
+    if i := strings.Index(base, "#"); i >= 0 {
+        base, fragment = base[:i], base[i:]
+    }
+    switch {
+    case !strings.Contains(base, "?"):
+        base += "?"
+    case !strings.HasSuffix(base, "?") && !strings.HasSuffix(base, "&"):
+        base += "&"
+    }
+    return base + query + fragment
+}
+
+func newHash(algo string) (hash.Hash, error) {
+    switch strings.ToLower(algo) {
+    case "md5":
+        return md5.New(), nil
+    case "sha1":
+        return sha1.New(), nil
+    case "sha256":
+        return sha256.New(), nil
+    }
+    return nil, fmt.Errorf("unknown hash algorithm %q", algo)
+}
+
+func hexDigest(h hash.Hash, data []byte) string {
+    h.Write(data)
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile digests the file at path without loading it into memory.
+func hashFile(path string, algo string) (string, error) {
+    h, err := newHash(algo)
+    if err != nil {
+        return "", err
+    }
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+    if _, err := io.Copy(h, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func randomBytes(n C.int) ([]byte, error) {
+    if n < 0 {
+        return nil, fmt.Errorf("invalid random length %d", n)
+    }
+    b := make([]byte, int(n))
+    if _, err := rand.Read(b); err != nil {
+        return nil, err
+    }
+    return b, nil
+}
+
+// writeFile opens path for writing with the extra open flag mode and
+// writes data to it.
+func writeFile(path string, mode int, data []byte) C.int {
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|mode, 0644)
+    if err == nil {
+        _, err = file.Write(data)
+        if closeErr := file.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFile
+    }
+    return 0
+}
+
+// newUploadRequest builds a multipart/form-data POST with the text fields
+// followed by the contents of filePath under fieldName. The file is
+// streamed into the body rather than read up front.
+func newUploadRequest(url string, fieldName string, filePath string, fields http.Header) (*http.Request, error) {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return nil, err
+    }
+
+    reader, writer := io.Pipe()
+    form := multipart.NewWriter(writer)
+    go func() {
+        defer file.Close()
+        writer.CloseWithError(writeUpload(form, fieldName, file, fields))
+    }()
+
+    req, err := http.NewRequest("POST", url, reader)
+    if err != nil {
+        reader.Close()
+        return nil, err
+    }
+    req.Header.Set("Content-Type", form.FormDataContentType())
+    return req, nil
+}
+
+func writeUpload(form *multipart.Writer, fieldName string, file *os.File, fields http.Header) error {
+    for key, values := range fields {
+        for _, value := range values {
+            if err := form.WriteField(key, value); err != nil {
+                return err
+            }
+        }
+    }
+    part, err := form.CreateFormFile(fieldName, filepath.Base(file.Name()))
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(part, file); err != nil {
+        return err
+    }
+    return form.Close()
+}
+
+func upload(url *C.char, fieldName *C.char, filePath *C.char, fields http.Header) *C.char {
+    req, err := newUploadRequest(C.GoString(url), C.GoString(fieldName), C.GoString(filePath), fields)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(transferClient.http, req))
+}
+
+// serverMaxBody caps the request bodies handed to server callbacks; a
+// larger one gets 413 without the callback being called.
+const serverMaxBody = 16 << 20
+
+// httpServer is an HTTP server kept behind a server handle, dispatching
+// each route to a C callback.
+type httpServer struct {
+    server *http.Server
+    mux    *http.ServeMux
+
+    mu       sync.Mutex
+    listener net.Listener
+}
+
+func lookupServer(handle C.longlong) (*httpServer, error) {
+    srv, ok := loadHandle(handle).(*httpServer)
+    if !ok {
+        return nil, handleError("server", handle)
+    }
+    return srv, nil
+}
+
+// route registers callback for pattern. ServeMux panics on a duplicate or
+// malformed pattern, which is turned into an error here.
+func (s *httpServer) route(pattern string, callback C.uintptr_t) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+    s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+        body, err := ioutil.ReadAll(io.LimitReader(r.Body, serverMaxBody+1))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if len(body) > serverMaxBody {
+            http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+            return
+        }
+        cBody := C.CString(string(body))
+        defer C.free(unsafe.Pointer(cBody))
+
+        reply := C.hio_call_handler(callback, cBody)
+        if reply == nil {
+            return
+        }
+        defer C.free(unsafe.Pointer(reply))
+        io.WriteString(w, C.GoString(reply))
+    })
+    return nil
+}
+
+func (s *httpServer) start() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.listener != nil {
+        return errors.New("server already started")
+    }
+    listener, err := net.Listen("tcp", s.server.Addr)
+    if err != nil {
+        return err
+    }
+    s.listener = listener
+    go s.server.Serve(listener)
+    return nil
+}
+
+// port returns the port the server listens on once started.
+func (s *httpServer) port() (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.listener == nil {
+        return 0, errors.New("server not started")
+    }
+    return s.listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (s *httpServer) Close() error {
+    return s.server.Close()
+}
+
+//-------------------------
+//-------------------------
+
+// streamConn is a connected stream socket kept behind a connection handle.
+type streamConn struct {
+    net.Conn
+}
+
+func lookupConn(handle C.longlong) (*streamConn, error) {
+    conn, ok := loadHandle(handle).(*streamConn)
+    if !ok {
+        return nil, handleError("connection", handle)
+    }
+    return conn, nil
+}
+
+// deadline turns a timeout from Hiolang into an absolute deadline, with 0
+// meaning none.
+func deadline(timeoutMs C.longlong) time.Time {
+    if timeoutMs <= 0 {
+        return time.Time{}
+    }
+    return time.Now().Add(millis(timeoutMs))
+}
+
+// setConnDeadline applies set to the connection behind handle, returning
+// 0 on success and -1 on failure.
+func setConnDeadline(handle C.longlong, timeoutMs C.longlong, set func(net.Conn, time.Time) error) C.int {
+    conn, err := lookupConn(handle)
+    if err == nil {
+        err = set(conn, deadline(timeoutMs))
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return 0
+}
+
+// configureTCP applies fn to the TCP connection behind handle, returning
+// 0 on success and -1 on failure or when the handle holds some other kind
+// of stream.
+func configureTCP(handle C.longlong, fn func(*net.TCPConn) error) C.int {
+    conn, err := lookupConn(handle)
+    if err == nil {
+        tcp, ok := conn.Conn.(*net.TCPConn)
+        if !ok {
+            err = fmt.Errorf("connection handle %d is not a TCP connection", handle)
+        } else {
+            err = fn(tcp)
+        }
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return 0
+}
+
+func hostPort(host *C.char, port C.int) string {
+    return net.JoinHostPort(C.GoString(host), strconv.Itoa(int(port)))
+}
+
+// tcpRequest connects to addr, sends request and reads until the server
+// closes the connection, all within timeout (0 for no limit). What
+// arrived before a timeout or error is returned along with it.
+func tcpRequest(addr string, request []byte, timeout time.Duration) ([]byte, error) {
+    var end time.Time
+    if timeout > 0 {
+        end = time.Now().Add(timeout)
+    }
+    conn, err := (&net.Dialer{Deadline: end}).Dial("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(end)
+    if _, err := conn.Write(request); err != nil {
+        return nil, err
+    }
+    return ioutil.ReadAll(conn)
+}
+
+// recvResult hands back n bytes read from a socket. *outLen is the byte
+// count, 0 at end of stream, -2 when a deadline passed with no data read
+// and -1 on any other error. Data read before an error is still returned.
+func recvResult(buf []byte, n int, err error, outLen *C.int) *C.char {
+    if n > 0 || err == io.EOF {
+        return bytesResult(buf[:n], outLen, nil)
+    }
+    if outLen != nil {
+        *outLen = errorCode(err)
+    }
+    return stringResult("", err)
+}
+
+func lookupListener(handle C.longlong) (*net.TCPListener, error) {
+    listener, ok := loadHandle(handle).(*net.TCPListener)
+    if !ok {
+        return nil, handleError("listener", handle)
+    }
+    return listener, nil
+}
+
+// packetConn is a datagram socket kept behind a UDP handle.
+type packetConn struct {
+    net.PacketConn
+}
+
+func lookupPacketConn(handle C.longlong) (*packetConn, error) {
+    conn, ok := loadHandle(handle).(*packetConn)
+    if !ok {
+        return nil, handleError("UDP", handle)
+    }
+    return conn, nil
+}
+
+// dnsError prefixes resolver failures so that a name that does not exist
+// can be told apart from a resolver that timed out.
+func dnsError(err error) error {
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        switch {
+        case dnsErr.IsNotFound:
+            return fmt.Errorf("dns not found: %w", err)
+        case dnsErr.IsTimeout:
+            return fmt.Errorf("dns timeout: %w", err)
+        }
+    }
+    return err
+}
+
+// resolver answers the Hio*Dns* lookups; HioDnsSetResolver_c replaces it.
+var resolver = struct {
+    sync.Mutex
+    value *net.Resolver
+}{value: net.DefaultResolver}
+
+func currentResolver() *net.Resolver {
+    resolver.Lock()
+    defer resolver.Unlock()
+    return resolver.value
+}
+
+// newResolver returns a resolver that sends every query to server, a
+// "host:port" address. Port 53 is assumed when none is given.
+func newResolver(server string) (*net.Resolver, error) {
+    if _, _, err := net.SplitHostPort(server); err != nil {
+        server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+    }
+    host, _, err := net.SplitHostPort(server)
+    if err != nil {
+        return nil, err
+    }
+    if net.ParseIP(host) == nil {
+        return nil, fmt.Errorf("dns server %q is not an IP address", host)
+    }
+    return &net.Resolver{
+        PreferGo: true,
+        Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+            var dialer net.Dialer
+            return dialer.DialContext(ctx, network, server)
+        },
+    }, nil
+}
+
+func lookupIPs(host string) (string, error) {
+    addrs, err := currentResolver().LookupIPAddr(context.Background(), host)
+    if err != nil {
+        return "", dnsError(err)
+    }
+    ips := make([]string, len(addrs))
+    for i, addr := range addrs {
+        ips[i] = addr.IP.String()
+    }
+    return strings.Join(ips, ","), nil
+}
+
+func lookupNames(ip string) (string, error) {
+    names, err := currentResolver().LookupAddr(context.Background(), ip)
+    if err != nil {
+        return "", dnsError(err)
+    }
+    for i, name := range names {
+        names[i] = strings.TrimSuffix(name, ".")
+    }
+    return strings.Join(names, ","), nil
+}
+
+func lookupTXT(host string) (string, error) {
+    records, err := currentResolver().LookupTXT(context.Background(), host)
+    if err != nil {
+        return "", dnsError(err)
+    }
+    return strings.Join(records, "\n"), nil
+}
+
+func lookupMX(host string) (string, error) {
+    records, err := currentResolver().LookupMX(context.Background(), host)
+    if err != nil {
+        return "", dnsError(err)
+    }
+    entries := make([]string, len(records))
+    for i, mx := range records {
+        entries[i] = fmt.Sprintf("%d %s", mx.Pref, strings.TrimSuffix(mx.Host, "."))
+    }
+    return strings.Join(entries, ","), nil
+}
+
+// checkHostname applies the RFC 1123 rules: at most 253 characters
+// without the optional trailing dot, and labels of 1 to 63 letters, digits
+// and hyphens that neither start nor end with a hyphen.
+func checkHostname(host string) error {
+    name := strings.TrimSuffix(host, ".")
+    if name == "" || len(name) > 253 {
+        return fmt.Errorf("hostname %q: must be 1 to 253 characters", host)
+    }
+    for _, label := range strings.Split(name, ".") {
+        if label == "" || len(label) > 63 {
+            return fmt.Errorf("hostname %q: label %q must be 1 to 63 characters", host, label)
+        }
+        if label[0] == '-' || label[len(label)-1] == '-' {
+            return fmt.Errorf("hostname %q: label %q starts or ends with a hyphen", host, label)
+        }
+        for _, c := range []byte(label) {
+            if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+                return fmt.Errorf("hostname %q: label %q contains %q", host, label, c)
+            }
+        }
+    }
+    return nil
+}
+
+// normalizeHostname lowercases host and converts each label holding
+// non-ASCII characters to its punycode "xn--" form (RFC 3492). The IDNA
+// dot variants are accepted as separators. Unlike full UTS #46 processing
+// no Unicode normalisation or mapping is applied besides lowercasing.
+func normalizeHostname(host string) (string, error) {
+    host = strings.ToLower(host)
+    host = strings.NewReplacer("\u3002", ".", "\uFF0E", ".", "\uFF61", ".").Replace(host)
+    labels := strings.Split(host, ".")
+    for i, label := range labels {
+        ascii := true
+        for _, r := range label {
+            if r >= 0x80 {
+                ascii = false
+                break
+            }
+        }
+        if !ascii {
+            labels[i] = "xn--" + punycode(label)
+        }
+    }
+    normalized := strings.Join(labels, ".")
+    return normalized, checkHostname(normalized)
+}
+
+const (
+    punyBase = 36
+    punyTMin = 1
+    punyTMax = 26
+)
+
+// punycode encodes label following RFC 3492 section 6.3.
+func punycode(label string) string {
+    runes := []rune(label)
+    var out []byte
+    for _, r := range runes {
+        if r < 0x80 {
+            out = append(out, byte(r))
+        }
+    }
+    basic := len(out)
+    if basic > 0 {
+        out = append(out, '-')
+    }
+
+    n, delta, bias := rune(0x80), 0, 72
+    for handled := basic; handled < len(runes); {
+        next := rune(math.MaxInt32)
+        for _, r := range runes {
+            if r >= n && r < next {
+                next = r
+            }
+        }
+        delta += int(next-n) * (handled + 1)
+        n = next
+        for _, r := range runes {
+            if r < n {
+                delta++
+            }
+            if r != n {
+                continue
+            }
+            q := delta
+            for k := punyBase; ; k += punyBase {
+                t := k - bias
+                if t < punyTMin {
+                    t = punyTMin
+                } else if t > punyTMax {
+                    t = punyTMax
+                }
+                if q < t {
+                    break
+                }
+                out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+                q = (q - t) / (punyBase - t)
+            }
+            out = append(out, punyDigit(q))
+            bias = punyAdapt(delta, handled+1, handled == basic)
+            delta = 0
+            handled++
+        }
+        delta++
+        n++
+    }
+    return string(out)
+}
+
+func punyDigit(d int) byte {
+    if d < 26 {
+        return byte('a' + d)
+    }
+    return byte('0' + d - 26)
+}
+
+func punyAdapt(delta int, points int, first bool) int {
+    if first {
+        delta /= 700
+    } else {
+        delta /= 2
+    }
+    delta += delta / points
+    k := 0
+    for delta > (punyBase-punyTMin)*punyTMax/2 {
+        delta /= punyBase - punyTMin
+        k += punyBase
+    }
+    return k + (punyBase-punyTMin+1)*delta/(delta+38)
+}
+
+// localIPs lists the addresses of every interface that is up, loopback
+// excluded.
+func localIPs() (string, error) {
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        return "", err
+    }
+    var ips []string
+    for _, iface := range ifaces {
+        if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+            continue
+        }
+        addrs, err := iface.Addrs()
+        if err != nil {
+            return "", err
+        }
+        for _, addr := range addrs {
+            if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+                ips = append(ips, ipNet.IP.String())
+            }
+        }
+    }
+    return strings.Join(ips, ","), nil
+}
+
+// outboundIP reports the source address the system would pick to reach
+// the internet. Connecting a UDP socket sends nothing, so no traffic
+// leaves the machine.
+func outboundIP() (string, error) {
+    conn, err := net.Dial("udp", "8.8.8.8:80")
+    if err != nil {
+        return "", err
+    }
+    defer conn.Close()
+    return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+// and the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// ntpTime asks server for the current time with a single SNTP request.
+// Half the round trip is added to the server's transmit timestamp to
+// account for the reply's time in flight.
+func ntpTime(server string) (time.Time, error) {
+    if server == "" {
+        server = "pool.ntp.org"
+    }
+    if _, _, err := net.SplitHostPort(server); err != nil {
+        server = net.JoinHostPort(server, "123")
+    }
+    conn, err := net.DialTimeout("udp", server, time.Second*5)
+    if err != nil {
+        return time.Time{}, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+    // Leap indicator 0, version 3, mode 3 (client).
+    request := make([]byte, 48)
+    request[0] = 0x1B
+    start := time.Now()
+    if _, err := conn.Write(request); err != nil {
+        return time.Time{}, err
+    }
+    reply := make([]byte, 128)
+    n, err := conn.Read(reply)
+    if err != nil {
+        return time.Time{}, err
+    }
+    rtt := time.Since(start)
+    if n < 48 || reply[0]&0x07 != 4 || reply[1] == 0 {
+        return time.Time{}, errors.New("ntp: invalid reply")
+    }
+
+    seconds := binary.BigEndian.Uint32(reply[40:])
+    fraction := binary.BigEndian.Uint32(reply[44:])
+    nanos := (int64(fraction) * 1e9) >> 32
+    return time.Unix(int64(seconds)-ntpEpochOffset, nanos).Add(rtt / 2), nil
+}
+
+// listenICMP opens a socket for ICMP echo. A raw socket, which usually
+// needs elevated privileges, is tried first; failing that, the
+// unprivileged datagram flavour offered by Linux and macOS. raw tells the
+// caller which one it got, since the kernel rewrites the echo id of the
+// latter and expects UDP-style addresses.
+func listenICMP() (conn net.PacketConn, raw bool, err error) {
+    conn, err = net.ListenPacket("ip4:icmp", "0.0.0.0")
+    if err == nil {
+        return conn, true, nil
+    }
+
+    // 1 is IPPROTO_ICMP, which the syscall package does not define on
+    // every platform.
+    fd, sockErr := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 1)
+    if sockErr != nil {
+        return nil, false, err
+    }
+    if sockErr := syscall.Bind(fd, &syscall.SockaddrInet4{}); sockErr != nil {
+        syscall.Close(fd)
+        return nil, false, err
+    }
+    file := os.NewFile(uintptr(fd), "icmp")
+    defer file.Close()
+    conn, sockErr = net.FilePacketConn(file)
+    if sockErr != nil {
+        return nil, false, err
+    }
+    return conn, false, nil
+}
+
+func icmpChecksum(b []byte) uint16 {
+    var sum uint32
+    for i := 0; i+1 < len(b); i += 2 {
+        sum += uint32(b[i])<<8 | uint32(b[i+1])
+    }
+    if len(b)%2 == 1 {
+        sum += uint32(b[len(b)-1]) << 8
+    }
+    for sum>>16 != 0 {
+        sum = sum&0xFFFF + sum>>16
+    }
+    return ^uint16(sum)
+}
+
+// ping sends one ICMP echo request to host over IPv4 and waits for the
+// matching reply, returning the round-trip time.
+func ping(host string, timeout time.Duration) (time.Duration, error) {
+    addr, err := net.ResolveIPAddr("ip4", host)
+    if err != nil {
+        return 0, err
+    }
+    conn, raw, err := listenICMP()
+    if err != nil {
+        return 0, err
+    }
+    defer conn.Close()
+
+    var dst net.Addr = addr
+    if !raw {
+        dst = &net.UDPAddr{IP: addr.IP}
+    }
+    id := uint16(os.Getpid())
+    seq := uint16(mathrand.Intn(1 << 16))
+    request := []byte{8, 0, 0, 0, byte(id >> 8), byte(id), byte(seq >> 8), byte(seq), 'h', 'i', 'o'}
+    binary.BigEndian.PutUint16(request[2:], icmpChecksum(request))
+
+    if timeout > 0 {
+        conn.SetDeadline(time.Now().Add(timeout))
+    }
+    start := time.Now()
+    if _, err := conn.WriteTo(request, dst); err != nil {
+        return 0, err
+    }
+
+    buf := make([]byte, 1500)
+    for {
+        n, from, err := conn.ReadFrom(buf)
+        if err != nil {
+            return 0, err
+        }
+        var fromIP net.IP
+        switch from := from.(type) {
+        case *net.IPAddr:
+            fromIP = from.IP
+        case *net.UDPAddr:
+            fromIP = from.IP
+        }
+        reply := buf[:n]
+        if n < 8 || reply[0] != 0 || !fromIP.Equal(addr.IP) {
+            continue
+        }
+        if binary.BigEndian.Uint16(reply[6:]) != seq || (raw && binary.BigEndian.Uint16(reply[4:]) != id) {
+            continue
+        }
+        return time.Since(start), nil
+    }
+}
+
+func recv(conn net.Conn, maxLen C.int, outLen *C.int) *C.char {
+    if maxLen <= 0 {
+        return recvResult(nil, 0, fmt.Errorf("invalid receive length %d", maxLen), outLen)
+    }
+    buf := make([]byte, int(maxLen))
+    n, err := conn.Read(buf)
+    return recvResult(buf, n, err, outLen)
+}
+
+//-------------------------
+//-------------------------
+
+// A minimal RFC 6455 client: enough for text and binary messages, with
+// pings answered and fragmented messages reassembled transparently.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+    wsOpContinuation = 0x0
+    wsOpText         = 0x1
+    wsOpBinary       = 0x2
+    wsOpClose        = 0x8
+    wsOpPing         = 0x9
+    wsOpPong         = 0xA
+)
+
+const wsMaxMessage = 64 << 20
+
+var errWsClosed = errors.New("websocket: connection closed")
+
+// wsConn is a client connection kept behind a WebSocket handle. Reads
+// are serialised by readMu and writes by writeMu, so a thread can send
+// while another waits for a message.
+type wsConn struct {
+    conn    net.Conn
+    reader  *bufio.Reader
+    readMu  sync.Mutex
+    writeMu sync.Mutex
+
+    // partial collects the fragments of a message that is still arriving,
+    // so a timeout between fragments does not lose them.
+    partial    []byte
+    fragmented bool
+
+    // err is set once the stream can no longer be read, e.g. after a
+    // timeout in the middle of a frame.
+    err error
+}
+
+func lookupWebSocket(handle C.longlong) (*wsConn, error) {
+    ws, ok := loadHandle(handle).(*wsConn)
+    if !ok {
+        return nil, handleError("WebSocket", handle)
+    }
+    return ws, nil
+}
+
+func dialWebSocket(rawURL string) (*wsConn, error) {
+    u, err := neturl.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+
+    dialer := &net.Dialer{Timeout: time.Second * 10}
+    var conn net.Conn
+    switch u.Scheme {
+    case "ws":
+        conn, err = dialer.Dial("tcp", defaultPort(u, "80"))
+    case "wss":
+        conn, err = tls.DialWithDialer(dialer, "tcp", defaultPort(u, "443"), &tls.Config{
+            ServerName: u.Hostname(),
+        })
+    default:
+        return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    ws, err := websocketHandshake(conn, u)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return ws, nil
+}
+
+func defaultPort(u *neturl.URL, port string) string {
+    if u.Port() != "" {
+        return u.Host
+    }
+    return net.JoinHostPort(u.Hostname(), port)
+}
+
+func websocketHandshake(conn net.Conn, u *neturl.URL) (*wsConn, error) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    key := base64.StdEncoding.EncodeToString(nonce)
+
+    extra := ""
+    if ua := currentUserAgent(); ua != "" {
+        extra = "User-Agent: " + ua + "\r\n"
+    }
+
+    conn.SetDeadline(time.Now().Add(time.Second * 10))
+    _, err := fmt.Fprintf(conn, "GET %s HTTP/1.1\r\n"+
+        "Host: %s\r\n"+
+        "Upgrade: websocket\r\n"+
+        "Connection: Upgrade\r\n"+
+        "Sec-WebSocket-Key: %s\r\n"+
+        "Sec-WebSocket-Version: 13\r\n"+
+        "%s\r\n", u.RequestURI(), u.Host, key, extra)
+    if err != nil {
+        return nil, err
+    }
+
+    reader := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(reader, nil)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        return nil, fmt.Errorf("websocket: handshake failed with status %s", resp.Status)
+    }
+    sum := sha1.Sum([]byte(key + websocketGUID))
+    if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+        return nil, errors.New("websocket: handshake returned an invalid Sec-WebSocket-Accept")
+    }
+    conn.SetDeadline(time.Time{})
+
+    return &wsConn{conn: conn, reader: reader}, nil
+}
+
+// Close sends a normal-closure frame and closes the connection without
+// waiting for the server's reply.
+func (ws *wsConn) Close() error {
+    ws.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+    return ws.conn.Close()
+}
+
+// writeFrame sends payload as a single masked frame, as clients must.
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+    ws.writeMu.Lock()
+    defer ws.writeMu.Unlock()
+
+    frame := []byte{0x80 | opcode}
+    n := len(payload)
+    switch {
+    case n < 126:
+        frame = append(frame, 0x80|byte(n))
+    case n <= 0xFFFF:
+        frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+    default:
+        var ext [8]byte
+        binary.BigEndian.PutUint64(ext[:], uint64(n))
+        frame = append(append(frame, 0x80|127), ext[:]...)
+    }
+
+    var mask [4]byte
+    if _, err := rand.Read(mask[:]); err != nil {
+        return err
+    }
+    frame = append(frame, mask[:]...)
+    for i, b := range payload {
+        frame = append(frame, b^mask[i%4])
+    }
+
+    _, err := ws.conn.Write(frame)
+    return err
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+    var head [2]byte
+    if _, err = io.ReadFull(ws.reader, head[:]); err != nil {
+        return
+    }
+    fin = head[0]&0x80 != 0
+    opcode = head[0] & 0x0F
+    masked := head[1]&0x80 != 0
+
+    length := uint64(head[1] & 0x7F)
+    switch length {
+    case 126:
+        var ext [2]byte
+        if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+            return
+        }
+        length = uint64(binary.BigEndian.Uint16(ext[:]))
+    case 127:
+        var ext [8]byte
+        if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+            return
+        }
+        length = binary.BigEndian.Uint64(ext[:])
+    }
+    if length > wsMaxMessage {
+        err = errors.New("websocket: frame too large")
+        return
+    }
+
+    var mask [4]byte
+    if masked {
+        if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+            return
+        }
+    }
+    payload = make([]byte, length)
+    if _, err = io.ReadFull(ws.reader, payload); err != nil {
+        return
+    }
+    if masked {
+        for i := range payload {
+            payload[i] ^= mask[i%4]
+        }
+    }
+    return
+}
+
+// readMessage returns the next text or binary message. A timeout while
+// waiting for a frame leaves the connection usable; any failure inside a
+// frame, a timeout included, makes it unreadable from then on and is
+// never reported as a timeout. The caller must hold readMu.
+func (ws *wsConn) readMessage() ([]byte, error) {
+    for {
+        if ws.err != nil {
+            return nil, ws.err
+        }
+        if _, err := ws.reader.Peek(1); err != nil {
+            if !isTimeout(err) {
+                ws.err = err
+            }
+            return nil, err
+        }
+
+        fin, opcode, payload, err := ws.readFrame()
+        if err != nil {
+            // Part of the frame has been consumed, so waiting longer
+            // cannot bring the stream back in step: a timeout here is
+            // reported as a plain failure rather than one to retry.
+            if isTimeout(err) {
+                err = fmt.Errorf("websocket: timed out inside a frame, connection unusable (%v)", err)
+            }
+            ws.err = err
+            return nil, err
+        }
+
+        switch opcode {
+        case wsOpPing:
+            if err := ws.writeFrame(wsOpPong, payload); err != nil {
+                ws.err = err
+                return nil, err
+            }
+            continue
+        case wsOpPong:
+            continue
+        case wsOpClose:
+            if len(payload) > 2 {
+                payload = payload[:2]
+            }
+            ws.writeFrame(wsOpClose, payload)
+            ws.err = errWsClosed
+            return nil, ws.err
+        case wsOpText, wsOpBinary:
+            if ws.fragmented {
+                ws.err = errors.New("websocket: new message inside a fragmented one")
+                return nil, ws.err
+            }
+            ws.partial = payload
+        case wsOpContinuation:
+            if !ws.fragmented {
+                ws.err = errors.New("websocket: unexpected continuation frame")
+                return nil, ws.err
+            }
+            if len(ws.partial)+len(payload) > wsMaxMessage {
+                ws.err = errors.New("websocket: message too large")
+                return nil, ws.err
+            }
+            ws.partial = append(ws.partial, payload...)
+        default:
+            ws.err = fmt.Errorf("websocket: unknown opcode %d", opcode)
+            return nil, ws.err
+        }
+
+        ws.fragmented = !fin
+        if fin {
+            message := ws.partial
+            ws.partial = nil
+            return message, nil
+        }
+    }
+}
+
+//-------------------------
+//-------------------------
+
+const sseMaxEvent = 64 << 20
+
+var errSseClosed = errors.New("sse: stream closed")
+
+// sseStream is a Server-Sent Events subscription kept behind an SSE
+// handle. The body is parsed on a goroutine of its own, which hands each
+// event over on events, so that a wait for the next one can time out.
+type sseStream struct {
+    body   io.ReadCloser
+    events chan string
+    done   chan struct{}
+    once   sync.Once
+
+    // err says why the stream ended. It is set before events is closed.
+    err error
+}
+
+func lookupSSE(handle C.longlong) (*sseStream, error) {
+    stream, ok := loadHandle(handle).(*sseStream)
+    if !ok {
+        return nil, handleError("SSE", handle)
+    }
+    return stream, nil
+}
+
+func dialSSE(url string) (*sseStream, error) {
+    req, err := http.NewRequest("GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "text/event-stream")
+    req.Header.Set("Cache-Control", "no-cache")
+
+    resp, err := transferClient.http.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode/100 != 2 {
+        resp.Body.Close()
+        return nil, fmt.Errorf("sse: unexpected status %s", resp.Status)
+    }
+    stream := &sseStream{
+        body:   resp.Body,
+        events: make(chan string),
+        done:   make(chan struct{}),
+    }
+    go stream.read(bufio.NewReader(resp.Body))
+    return stream, nil
+}
+
+// read parses the event stream until it ends or the handle is closed.
+// Lines beginning "data:" are joined with newlines into the event's
+// payload and a blank line dispatches it. Comments and the event, id and
+// retry fields carry nothing Hiolang asks for and are skipped.
+func (s *sseStream) read(reader *bufio.Reader) {
+    defer close(s.events)
+    var data strings.Builder
+    hasData := false
+    for {
+        line, err := reader.ReadString('\n')
+        if err != nil {
+            // An event still being collected is incomplete, so it is
+            // dropped, as the SSE spec asks.
+            if err == io.EOF {
+                err = errSseClosed
+            }
+            s.err = err
+            return
+        }
+        line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+        if line == "" {
+            if hasData {
+                select {
+                case s.events <- data.String():
+                case <-s.done:
+                    s.err = errSseClosed
+                    return
+                }
+            }
+            data.Reset()
+            hasData = false
+            continue
+        }
+        field, value := line, ""
+        if i := strings.IndexByte(line, ':'); i >= 0 {
+            field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+        }
+        if field != "data" {
+            continue
+        }
+        if hasData {
+            data.WriteByte('\n')
+        }
+        data.WriteString(value)
+        hasData = true
+        if data.Len() > sseMaxEvent {
+            s.err = errors.New("sse: event too large")
+            return
+        }
+    }
+}
+
+// next waits up to timeout for the next event; 0 waits indefinitely.
+func (s *sseStream) next(timeout time.Duration) (string, error) {
+    var expired <-chan time.Time
+    if timeout > 0 {
+        timer := time.NewTimer(timeout)
+        defer timer.Stop()
+        expired = timer.C
+    }
+    select {
+    case data, ok := <-s.events:
+        if !ok {
+            return "", s.err
+        }
+        return data, nil
+    case <-expired:
+        return "", os.ErrDeadlineExceeded
+    }
+}
+
+// Close ends the subscription, which also stops the reading goroutine.
+func (s *sseStream) Close() error {
+    s.once.Do(func() { close(s.done) })
+    return s.body.Close()
+}
+
+//-------------------------
+//-------------------------
+
+var processStart = time.Now()
+
+// namedLayouts spares Hiolang callers Go's reference-time layout syntax.
+var namedLayouts = map[string]string{
+    "rfc3339":  time.RFC3339,
+    "iso8601":  "2006-01-02T15:04:05Z07:00",
+    "unixdate": time.UnixDate,
+    "rfc1123":  time.RFC1123,
+}
+
+// resolveLayout maps a named layout onto its Go layout string, leaving
+// anything else to be used as a Go layout directly. Empty means RFC 3339.
+func resolveLayout(layout string) string {
+    if layout == "" {
+        return time.RFC3339
+    }
+    if named, ok := namedLayouts[strings.ToLower(layout)]; ok {
+        return named
+    }
+    return layout
+}
+
+// callbackTimer is a scheduled callback kept behind a timer handle.
+type callbackTimer struct {
+    stop chan struct{}
+    once sync.Once
+}
+
+func lookupTimer(handle C.longlong) (*callbackTimer, error) {
+    t, ok := loadHandle(handle).(*callbackTimer)
+    if !ok {
+        return nil, handleError("timer", handle)
+    }
+    return t, nil
+}
+
+// startTimer calls callback after delay and then, unless period is 0,
+// every period until the timer is stopped. A one-shot timer releases its
+// handle once it has fired.
+func startTimer(delay time.Duration, period time.Duration, callback C.uintptr_t) C.longlong {
+    t := &callbackTimer{stop: make(chan struct{})}
+    handle := storeHandle(t)
+    go func() {
+        wait := time.NewTimer(delay)
+        defer wait.Stop()
+        for {
+            select {
+            case <-t.stop:
+                return
+            case <-wait.C:
+            }
+            // Both cases may be ready at once; a cleared timer must not fire.
+            if t.stopped() {
+                return
+            }
+            C.hio_call_timer(callback, handle)
+            if period == 0 {
+                releaseHandle(handle)
+                return
+            }
+            wait.Reset(period)
+        }
+    }()
+    return handle
+}
+
+func (t *callbackTimer) stopped() bool {
+    select {
+    case <-t.stop:
+        return true
+    default:
+        return false
+    }
+}
+
+func (t *callbackTimer) Close() error {
+    t.once.Do(func() { close(t.stop) })
+    return nil
+}
+
+// signalNames lists the signals HioIgnoreSignal_c accepts, all of which
+// exist on every platform Go supports.
+var signalNames = map[string]syscall.Signal{
+    "SIGHUP":  syscall.SIGHUP,
+    "SIGINT":  syscall.SIGINT,
+    "SIGQUIT": syscall.SIGQUIT,
+    "SIGTERM": syscall.SIGTERM,
+}
+
+// parseSignal accepts a signal name with or without the SIG prefix, in
+// any case.
+func parseSignal(name string) (syscall.Signal, error) {
+    upper := strings.ToUpper(strings.TrimSpace(name))
+    if !strings.HasPrefix(upper, "SIG") {
+        upper = "SIG" + upper
+    }
+    sig, ok := signalNames[upper]
+    if !ok {
+        return 0, fmt.Errorf("unsupported signal %q", name)
+    }
+    return sig, nil
+}
+
+// signalWatcher delivers termination signals to a C callback kept behind
+// a signal handle.
+type signalWatcher struct {
+    signals chan os.Signal
+    stop    chan struct{}
+    once    sync.Once
+}
+
+func lookupSignalWatcher(handle C.longlong) (*signalWatcher, error) {
+    w, ok := loadHandle(handle).(*signalWatcher)
+    if !ok {
+        return nil, handleError("signal", handle)
+    }
+    return w, nil
+}
+
+// watchSignals calls callback with the signal number each time SIGINT or
+// SIGTERM arrives, until the watcher is closed.
+func watchSignals(callback C.uintptr_t) *signalWatcher {
+    w := &signalWatcher{signals: make(chan os.Signal, 1), stop: make(chan struct{})}
+    signal.Notify(w.signals, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+        for {
+            select {
+            case <-w.stop:
+                return
+            case sig := <-w.signals:
+                C.hio_call_signal(callback, C.int(sig.(syscall.Signal)))
+            }
+        }
+    }()
+    return w
+}
+
+func (w *signalWatcher) Close() error {
+    w.once.Do(func() {
+        signal.Stop(w.signals)
+        close(w.stop)
+    })
+    return nil
+}
+
+//-------------------------
+//-------------------------
+
+// decodeJSON parses doc, keeping numbers as json.Number so that large
+// integers survive intact.
+func decodeJSON(doc string) (interface{}, error) {
+    decoder := json.NewDecoder(strings.NewReader(doc))
+    decoder.UseNumber()
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil {
+        return nil, err
+    }
+    return value, nil
+}
+
+// jsonPath walks a dotted path such as "data.items.0.name", where numeric
+// segments index into arrays. An empty path selects the whole document.
+func jsonPath(doc string, path string) (interface{}, error) {
+    value, err := decodeJSON(doc)
+    if err != nil || path == "" {
+        return value, err
+    }
+    for _, segment := range strings.Split(path, ".") {
+        switch node := value.(type) {
+        case map[string]interface{}:
+            next, ok := node[segment]
+            if !ok {
+                return nil, fmt.Errorf("json path %q: no key %q", path, segment)
+            }
+            value = next
+        case []interface{}:
+            i, err := strconv.Atoi(segment)
+            if err != nil || i < 0 || i >= len(node) {
+                return nil, fmt.Errorf("json path %q: no index %q", path, segment)
+            }
+            value = node[i]
+        default:
+            return nil, fmt.Errorf("json path %q: %q is not inside an object or array", path, segment)
+        }
+    }
+    return value, nil
+}
+
+func jsonString(doc string, path string) (string, error) {
+    value, err := jsonPath(doc, path)
+    if err != nil {
+        return "", err
+    }
+    s, ok := value.(string)
+    if !ok {
+        return "", fmt.Errorf("json path %q: not a string", path)
+    }
+    return s, nil
+}
+
+func jsonNumber(doc string, path string) (json.Number, error) {
+    value, err := jsonPath(doc, path)
+    if err != nil {
+        return "", err
+    }
+    n, ok := value.(json.Number)
+    if !ok {
+        return "", fmt.Errorf("json path %q: not a number", path)
+    }
+    return n, nil
+}
+
+func jsonArray(doc string, path string) ([]interface{}, error) {
+    value, err := jsonPath(doc, path)
+    if err != nil {
+        return nil, err
+    }
+    array, ok := value.([]interface{})
+    if !ok {
+        return nil, fmt.Errorf("json path %q: not an array", path)
+    }
+    return array, nil
+}
+
+// jsonSyntaxError adds the byte offset a syntax error was found at, which
+// encoding/json keeps out of the message.
+func jsonSyntaxError(err error) error {
+    var syntaxErr *json.SyntaxError
+    if errors.As(err, &syntaxErr) {
+        return fmt.Errorf("%v (at byte %d)", err, syntaxErr.Offset)
+    }
+    return err
+}
+
+// prettyJSON indents doc by indent per level, two spaces when empty.
+func prettyJSON(doc string, indent string) (string, error) {
+    if indent == "" {
+        indent = "  "
+    }
+    var buf bytes.Buffer
+    if err := json.Indent(&buf, []byte(doc), "", indent); err != nil {
+        return "", jsonSyntaxError(err)
+    }
+    return buf.String(), nil
+}
+
+func minifyJSON(doc string) (string, error) {
+    var buf bytes.Buffer
+    if err := json.Compact(&buf, []byte(doc)); err != nil {
+        return "", jsonSyntaxError(err)
+    }
+    return buf.String(), nil
+}
+
+// jsonElement re-encodes element index of the array at path.
+func jsonElement(doc string, path string, index int) (string, error) {
+    array, err := jsonArray(doc, path)
+    if err != nil {
+        return "", err
+    }
+    if index < 0 || index >= len(array) {
+        return "", fmt.Errorf("json path %q: index %d out of range (length %d)", path, index, len(array))
+    }
+    encoded, err := json.Marshal(array[index])
+    return string(encoded), err
+}
+
+// jsonObject is an object under construction behind a JSON handle. Keys
+// are encoded in the order they were first set.
+type jsonObject struct {
+    keys   []string
+    values map[string]interface{}
+}
+
+func (o *jsonObject) set(key string, value interface{}) {
+    if _, ok := o.values[key]; !ok {
+        o.keys = append(o.keys, key)
+    }
+    o.values[key] = value
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+    var buf bytes.Buffer
+    buf.WriteByte('{')
+    for i, key := range o.keys {
+        if i > 0 {
+            buf.WriteByte(',')
+        }
+        encodedKey, err := json.Marshal(key)
+        if err != nil {
+            return nil, err
+        }
+        encodedValue, err := json.Marshal(o.values[key])
+        if err != nil {
+            return nil, err
+        }
+        buf.Write(encodedKey)
+        buf.WriteByte(':')
+        buf.Write(encodedValue)
+    }
+    buf.WriteByte('}')
+    return buf.Bytes(), nil
+}
+
+func lookupJSON(handle C.longlong) (*jsonObject, error) {
+    obj, ok := loadHandle(handle).(*jsonObject)
+    if !ok {
+        return nil, handleError("JSON", handle)
+    }
+    return obj, nil
+}
+
+func jsonSet(handle C.longlong, key *C.char, value interface{}) {
+    obj, err := lookupJSON(handle)
+    if err != nil {
+        setLastError(err)
+        return
+    }
+    goKey := C.GoString(key)
+    editValue(func() { obj.set(goKey, value) })
+    setLastError(nil)
+}
+
+//-------------------------
+//-------------------------
+
+//export HioFree_c
+func HioFree_c(ptr *C.char) {
+    C.free(unsafe.Pointer(ptr))
+}
+
+//export HioLastError_c
+func HioLastError_c() *C.char {
+    return C.CString(C.GoString(C.hio_get_last_error()))
+}
+
+// Returns the category of the error HioLastError_c describes: 0 none,
+// 1 timeout, 2 DNS failure, 3 connection refused, 4 TLS handshake or
+// certificate error, 5 too many redirects, 6 response too large, 7 invalid
+// or freed handle and 8 anything else.
+//export HioLastErrorCode_c
+func HioLastErrorCode_c() C.int {
+    return C.hio_get_last_error_code()
+}
+
+// Sets the User-Agent for every later request that does not carry its own
+// through a header handle. An empty string restores Go's default.
+//export HioSetUserAgent_c
+func HioSetUserAgent_c(ua *C.char) {
+    goUA := C.GoString(ua)
+    if strings.ContainsAny(goUA, "\r\n") {
+        setLastError(errors.New("user agent must not contain line breaks"))
+        return
+    }
+    userAgent.Lock()
+    userAgent.value = goUA
+    userAgent.Unlock()
+    setLastError(nil)
+}
+
+// Caps the size of buffered response bodies at n bytes; 0 (the default)
+// means no limit. A larger body is cut at the limit and HioLastError_c
+// then starts with "response too large". Downloads, streamed responses and
+// WebSocket messages are not affected.
+//export HioSetMaxResponseBytes_c
+func HioSetMaxResponseBytes_c(n C.longlong) {
+    setLastError(nil)
+    if n < 0 {
+        n = 0
+    }
+    maxResponseBytes.Lock()
+    maxResponseBytes.value = int64(n)
+    maxResponseBytes.Unlock()
+}
+
+// Turns request capture on (non-zero) or off. While on, every HTTP request
+// the library sends replaces the dump returned by HioLastRequestDump_c.
+// Turning it off discards the dump. Off by default.
+//export HioSetDebugCapture_c
+func HioSetDebugCapture_c(enable C.int) {
+    setLastError(nil)
+    debugCapture.Lock()
+    debugCapture.enabled = enable != 0
+    if !debugCapture.enabled {
+        debugCapture.dump = ""
+    }
+    debugCapture.Unlock()
+}
+
+// Returns the last request sent while capture was on, in the style of
+// curl -v: the request line and headers prefixed with "> ", then the body
+// (the first 64 KiB). Authorization and Proxy-Authorization values are
+// replaced by "[redacted]". Requests go out from every thread, so with
+// several in flight this is whichever was sent last. Empty when nothing
+// has been captured.
+//export HioLastRequestDump_c
+func HioLastRequestDump_c() *C.char {
+    setLastError(nil)
+    debugCapture.Lock()
+    defer debugCapture.Unlock()
+    return C.CString(debugCapture.dump)
+}
+
+// Closes every open connection, listener and stream, cancels pending
+// requests, drops idle pooled connections and invalidates all handles.
+// Meant for shutdown; handles given out before the call must not be used
+// afterwards.
+//export HioNetCleanup_c
+func HioNetCleanup_c() {
+    setLastError(nil)
+    for _, value := range releaseAll() {
+        closeValue(value)
+    }
+    http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+    transferClient.transport.CloseIdleConnections()
+}
+
+// Returns the number of open TCP, UDP and WebSocket connections,
+// listeners and servers.
+//export HioOpenConnCount_c
+func HioOpenConnCount_c() C.int {
+    setLastError(nil)
+    return C.int(countHandles(isConn))
+}
+
+// Returns the number of live handles of any kind.
+//export HioOpenHandleCount_c
+func HioOpenHandleCount_c() C.int {
+    setLastError(nil)
+    return C.int(countHandles(func(interface{}) bool { return true }))
+}
+
+//export HioHttpGet_c
+func HioHttpGet_c(url *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+// Like HioHttpGet_c, but writes the body's full length to *outLen so
+// binary bodies with NUL bytes can be read in full.
+//export HioHttpGetBytes_c
+func HioHttpGetBytes_c(url *C.char, outLen *C.int) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+
+    body, err := fetchBody(sharedClient, req)
+    return bytesResult(body, outLen, err)
+}
+
+//export HioHttpGetStatus_c
+func HioHttpGetStatus_c(url *C.char) C.int {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+
+    resp, err := sharedClient.Do(req)
+    if err != nil {
+        setLastError(err)
+        return errorCode(err)
+    }
+
+    _, err = readBody(resp)
+    setLastError(err)
+
+    return C.int(resp.StatusCode)
+}
+
+// data is read up to its first NUL byte; use HioHttpPostBytes_c for
+// binary payloads.
+//export HioHttpPost_c
+func HioHttpPost_c(url *C.char, data *C.char) *C.char {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), "application/json")
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+//export HioHttpPostCt_c
+func HioHttpPostCt_c(url *C.char, data *C.char, contentType *C.char) *C.char {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), C.GoString(contentType))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+// Posts exactly length bytes of data, which may contain NUL bytes. An
+// empty contentType defaults to application/octet-stream.
+//export HioHttpPostBytes_c
+func HioHttpPostBytes_c(url *C.char, data *C.char, length C.int, contentType *C.char) *C.char {
+    req, err := newPostRequest(C.GoString(url), string(goBytes(data, length)), C.GoString(contentType))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+// Like HioHttpPostBytes_c but gzip-compresses the body and sends it with
+// Content-Encoding: gzip. Only use it with servers known to accept
+// compressed request bodies.
+//export HioHttpPostGzip_c
+func HioHttpPostGzip_c(url *C.char, data *C.char, length C.int, contentType *C.char) *C.char {
+    compressed, err := gzipBytes(goBytes(data, length))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    req, err := newPostRequest(C.GoString(url), string(compressed), C.GoString(contentType))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    req.Header.Set("Content-Encoding", "gzip")
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+// Posts the fields of a query handle (see HioQueryNew_c) as an
+// application/x-www-form-urlencoded body.
+//export HioHttpPostForm_c
+func HioHttpPostForm_c(url *C.char, formHandle C.longlong) *C.char {
+    form, err := lookupQuery(formHandle)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    var body string
+    viewValue(func() { body = form.Encode() })
+    req, err := newPostRequest(C.GoString(url), body, "application/x-www-form-urlencoded")
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+//export HioHttpGetTimeout_c
+func HioHttpGetTimeout_c(url *C.char, timeoutMs C.longlong) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(clientWithTimeout(timeoutMs), req))
+}
+
+//export HioHttpPostTimeout_c
+func HioHttpPostTimeout_c(url *C.char, data *C.char, timeoutMs C.longlong) *C.char {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), "application/json")
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(clientWithTimeout(timeoutMs), req))
+}
+
+//export HioHttpRequest_c
+func HioHttpRequest_c(method *C.char, url *C.char, body *C.char) *C.char {
+    req, err := newRequest(C.GoString(method), C.GoString(url), C.GoString(body))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+//export HioHeaderNew_c
+func HioHeaderNew_c() C.longlong {
+    setLastError(nil)
+    return storeHandle(http.Header{})
+}
+
+// Setting a key that is already present adds another value for it. Keys
+// keep their spelling so the set can also carry multipart form fields;
+// they are canonicalized when applied to a request.
+//export HioHeaderSet_c
+func HioHeaderSet_c(handle C.longlong, key *C.char, value *C.char) {
+    header, err := lookupHeader(handle)
+    if err != nil {
+        setLastError(err)
+        return
+    }
+    goKey, goValue := C.GoString(key), C.GoString(value)
+    editValue(func() { header[goKey] = append(header[goKey], goValue) })
+    setLastError(nil)
+}
+
+//export HioHeaderFree_c
+func HioHeaderFree_c(handle C.longlong) {
+    if _, err := lookupHeader(handle); err != nil {
+        setLastError(err)
+        return
+    }
+    freeHandle("header", handle)
+}
+
+//export HioHttpGetWithHeaders_c
+func HioHttpGetWithHeaders_c(url *C.char, handle C.longlong) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return fetchWithHeaders(req, handle)
+}
+
+//export HioHttpPostWithHeaders_c
+func HioHttpPostWithHeaders_c(url *C.char, data *C.char, handle C.longlong) *C.char {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), "application/json")
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return fetchWithHeaders(req, handle)
+}
+
+//export HioHttpRequestWithHeaders_c
+func HioHttpRequestWithHeaders_c(method *C.char, url *C.char, body *C.char, handle C.longlong) *C.char {
+    req, err := newRequest(C.GoString(method), C.GoString(url), C.GoString(body))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return fetchWithHeaders(req, handle)
+}
+
+//export HioHttpGetBasicAuth_c
+func HioHttpGetBasicAuth_c(url *C.char, user *C.char, pass *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    req.SetBasicAuth(C.GoString(user), C.GoString(pass))
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+//export HioHttpGetBearer_c
+func HioHttpGetBearer_c(url *C.char, token *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    req.Header.Set("Authorization", "Bearer "+C.GoString(token))
+
+    return bodyResult(fetchBody(sharedClient, req))
+}
+
+// Asks for a gzip-encoded body and decompresses it when the server obliges;
+// a body sent without Content-Encoding: gzip is returned as is. The
+// HioSetMaxResponseBytes_c cap applies to the decompressed body too.
+//export HioHttpGetGzip_c
+func HioHttpGetGzip_c(url *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    req.Header.Set("Accept-Encoding", "gzip")
+
+    resp, err := fetchResponse(sharedClient, req)
+    if err != nil && !errors.Is(err, errResponseTooLarge) {
+        return bodyResult(nil, err)
+    }
+    if !strings.EqualFold(resp.header.Get("Content-Encoding"), "gzip") {
+        return bodyResult(resp.body, err)
+    }
+    decoded, gzErr := gunzip(resp.body, responseLimit())
+    // A body cut at the cap cannot decompress cleanly; the cap is the
+    // error worth reporting.
+    if err == nil {
+        err = gzErr
+    }
+    return bodyResult(decoded, err)
+}
+
+// Returns the body transcoded to UTF-8 from the charset declared in
+// Content-Type, a byte order mark or, for HTML, a <meta charset> tag;
+// UTF-8 is assumed otherwise. Every charset browsers support is
+// understood, among them Shift_JIS, EUC-JP, GBK/GB18030, Big5, EUC-KR,
+// KOI8-R, windows-1251 and ISO-8859-2 to -16. An unknown charset leaves
+// the body as is and sets HioLastError_c.
+//export HioHttpGetText_c
+func HioHttpGetText_c(url *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return stringResult("", err)
+    }
+
+    resp, err := fetchResponse(sharedClient, req)
+    if err != nil && !errors.Is(err, errResponseTooLarge) {
+        return stringResult("", err)
+    }
+    text, decodeErr := decodeText(resp.body, bodyCharset(resp.header.Get("Content-Type"), resp.body))
+    if err == nil {
+        err = decodeErr
+    }
+    return stringResult(text, err)
+}
+
+// Tries up to maxAttempts times, retrying connection errors and 5xx/429
+// responses with exponential backoff from baseDelayMs. A server's
+// Retry-After is honoured, but no wait exceeds a minute. *outAttempts
+// (which may be NULL) receives the number of attempts made, on success
+// as on failure; 0 means the URL was rejected before any attempt. Once the
+// attempts are used up it returns the last body and sets HioLastError_c.
+//export HioHttpGetRetry_c
+func HioHttpGetRetry_c(url *C.char, maxAttempts C.int, baseDelayMs C.longlong, outAttempts *C.int) *C.char {
+    if outAttempts != nil {
+        *outAttempts = 0
+    }
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    if maxAttempts < 1 {
+        maxAttempts = 1
+    }
+
+    resp, attempts, err := fetchWithRetry(sharedClient, req, int(maxAttempts), millis(baseDelayMs))
+    if outAttempts != nil {
+        *outAttempts = C.int(attempts)
+    }
+    if resp == nil {
+        return bodyResult(nil, err)
+    }
+    return bodyResult(resp.body, err)
+}
+
+// Fetches every url in the separator-delimited list, at most concurrency
+// at a time, and returns the bodies joined by the same separator in input
+// order. A failed request leaves its slot empty; HioLastError_c then lists
+// each failure on its own line.
+//export HioHttpGetBatch_c
+func HioHttpGetBatch_c(urls *C.char, separator *C.char, concurrency C.int) *C.char {
+    sep := C.GoString(separator)
+    if sep == "" {
+        return stringResult("", errors.New("empty separator"))
+    }
+
+    list := strings.Split(C.GoString(urls), sep)
+    bodies, err := fetchBatch(list, int(concurrency))
+    return stringResult(strings.Join(bodies, sep), err)
+}
+
+// Starts a GET in the background and returns a handle to poll with
+// HioAsyncReady_c, or 0 if url is invalid.
+//export HioHttpGetAsync_c
+func HioHttpGetAsync_c(url *C.char) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(startAsync(req))
+}
+
+// Returns 1 once the request has finished, 0 while it is running and -1
+// for an unknown handle.
+//export HioAsyncReady_c
+func HioAsyncReady_c(handle C.longlong) C.int {
+    async, err := lookupAsync(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    select {
+    case <-async.done:
+        return 1
+    default:
+        return 0
+    }
+}
+
+// Returns the body, waiting for the request to finish if it has not yet.
+// The wait is bounded by the usual 10s request timeout.
+//export HioAsyncResult_c
+func HioAsyncResult_c(handle C.longlong) *C.char {
+    async, err := lookupAsync(handle)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    <-async.done
+    return bodyResult(async.body, async.err)
+}
+
+// Cancels the request if it is still running and releases the handle.
+//export HioAsyncFree_c
+func HioAsyncFree_c(handle C.longlong) {
+    async, err := lookupAsync(handle)
+    setLastError(err)
+    if err == nil && freeHandle("async", handle) != nil {
+        async.cancel()
+    }
+}
+
+//export HioHttpGetResp_c
+func HioHttpGetResp_c(url *C.char) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return responseResult(fetchResponse(sharedClient, req))
+}
+
+// Sends If-None-Match: etag and If-Modified-Since: lastModified, skipping
+// either when empty. Pass the ETag and Last-Modified headers of an earlier
+// response as they were received. If nothing has changed the handle's
+// status is 304 and its body is empty, so the earlier copy can be reused.
+//export HioHttpGetConditional_c
+func HioHttpGetConditional_c(url *C.char, etag *C.char, lastModified *C.char) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+    if goETag := C.GoString(etag); goETag != "" {
+        req.Header.Set("If-None-Match", goETag)
+    }
+    if goLastModified := C.GoString(lastModified); goLastModified != "" {
+        req.Header.Set("If-Modified-Since", goLastModified)
+    }
+
+    return responseResult(fetchResponse(sharedClient, req))
+}
+
+// Returns a response handle as soon as the headers arrive, leaving the body
+// to be consumed with HioRespReadChunk_c or HioRespReadLine_c. Only the
+// wait for the headers is bounded, so the stream can stay open for as long
+// as the server keeps sending. The connection is held until HioRespFree_c.
+//export HioHttpGetStream_c
+func HioHttpGetStream_c(url *C.char) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return responseResult(openResponse(transferClient.http, req))
+}
+
+//export HioHttpPostResp_c
+func HioHttpPostResp_c(url *C.char, data *C.char) C.longlong {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), "application/json")
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return responseResult(fetchResponse(sharedClient, req))
+}
+
+// Sends a HEAD request and returns a response handle with the status and
+// headers filled in and an empty body.
+//export HioHttpHead_c
+func HioHttpHead_c(url *C.char) C.longlong {
+    req, err := http.NewRequest("HEAD", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return responseResult(fetchResponse(sharedClient, req))
+}
+
+// The returned handle holds the redirect response itself, so its status
+// and Location header can be inspected.
+//export HioHttpGetNoRedirect_c
+func HioHttpGetNoRedirect_c(url *C.char) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return responseResult(fetchResponse(clientWithRedirectLimit(-1), req))
+}
+
+// Follows redirects from url and returns one "status url" line per hop,
+// e.g. "301 http://example.com/" followed by "200 https://example.com/".
+// The last line is the final response.
+//export HioHttpTraceRedirects_c
+func HioHttpTraceRedirects_c(url *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return stringResult("", err)
+    }
+
+    return stringResult(traceRedirects(req))
+}
+
+//export HioHttpGetMaxRedirects_c
+func HioHttpGetMaxRedirects_c(url *C.char, max C.int) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+    if max < 0 {
+        max = 0
+    }
+
+    return responseResult(fetchResponse(clientWithRedirectLimit(int(max)), req))
+}
+
+//export HioRespStatus_c
+func HioRespStatus_c(handle C.longlong) C.int {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.int(resp.status)
+}
+
+// For a streamed response, reads whatever is left of the body.
+//export HioRespBody_c
+func HioRespBody_c(handle C.longlong) *C.char {
+    resp, err := lookupResponse(handle)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    if resp.closer != nil {
+        resp.readMu.Lock()
+        defer resp.readMu.Unlock()
+        return bodyResult(ioutil.ReadAll(resp.stream))
+    }
+    return bodyResult(resp.body, nil)
+}
+
+// Reads up to maxLen bytes of the body; see recvResult for the meaning of
+// *outLen, which is 0 at the end of the body.
+//export HioRespReadChunk_c
+func HioRespReadChunk_c(handle C.longlong, maxLen C.int, outLen *C.int) *C.char {
+    resp, err := lookupResponse(handle)
+    if err != nil {
+        return recvResult(nil, 0, err, outLen)
+    }
+    if maxLen <= 0 {
+        return recvResult(nil, 0, fmt.Errorf("invalid read length %d", maxLen), outLen)
+    }
+    buf := make([]byte, int(maxLen))
+    resp.readMu.Lock()
+    defer resp.readMu.Unlock()
+    n, err := resp.reader().Read(buf)
+    return recvResult(buf, n, err, outLen)
+}
+
+// Reads the next line of the body, trailing "\n" included, so an empty
+// line still has length 1 and *outLen is 0 only at the end of the body.
+// The last line may lack the newline.
+//export HioRespReadLine_c
+func HioRespReadLine_c(handle C.longlong, outLen *C.int) *C.char {
+    resp, err := lookupResponse(handle)
+    if err != nil {
+        return recvResult(nil, 0, err, outLen)
+    }
+    resp.readMu.Lock()
+    defer resp.readMu.Unlock()
+    line, err := resp.reader().ReadBytes('\n')
+    return recvResult(line, len(line), err, outLen)
+}
+
+// Returns the first value of the named header, or an empty string.
+//export HioRespHeader_c
+func HioRespHeader_c(handle C.longlong, key *C.char) *C.char {
+    resp, err := lookupResponse(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    return stringResult(resp.header.Get(C.GoString(key)), nil)
+}
+
+// Returns the length the server declared for the body, or -1 when it sent
+// none.
+//export HioRespContentLength_c
+func HioRespContentLength_c(handle C.longlong) C.longlong {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.longlong(resp.length)
+}
+
+// Returns the negotiated protocol version, e.g. "TLS 1.3".
+//export HioRespTlsVersion_c
+func HioRespTlsVersion_c(handle C.longlong) *C.char {
+    return tlsPart(handle, func(state *tls.ConnectionState) string {
+        if name, ok := tlsVersions[state.Version]; ok {
+            return name
+        }
+        return fmt.Sprintf("0x%04X", state.Version)
+    })
+}
+
+// Returns the cipher suite name, e.g. "TLS_AES_128_GCM_SHA256".
+//export HioRespTlsCipher_c
+func HioRespTlsCipher_c(handle C.longlong) *C.char {
+    return tlsPart(handle, func(state *tls.ConnectionState) string {
+        return tls.CipherSuiteName(state.CipherSuite)
+    })
+}
+
+// Returns the subject of the server's leaf certificate as a
+// distinguished name, e.g. "CN=example.com,O=Example".
+//export HioRespPeerCertSubject_c
+func HioRespPeerCertSubject_c(handle C.longlong) *C.char {
+    return tlsPart(handle, func(state *tls.ConnectionState) string {
+        if len(state.PeerCertificates) == 0 {
+            return ""
+        }
+        return state.PeerCertificates[0].Subject.String()
+    })
+}
+
+// Returns the Unix time at which the server's leaf certificate expires, or
+// -1 for a plain-HTTP response or an unknown handle.
+//export HioRespPeerCertExpiry_c
+func HioRespPeerCertExpiry_c(handle C.longlong) C.longlong {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err != nil || resp.tlsState == nil || len(resp.tlsState.PeerCertificates) == 0 {
+        return hioErrFailed
+    }
+    return C.longlong(resp.tlsState.PeerCertificates[0].NotAfter.Unix())
+}
+
+// Returns 1 if the response was served from the client's cache (see
+// HioClientEnableCache_c and HioClientGetResp_c), 0 if it came over the
+// network and -1 for an invalid handle. Responses fetched without a
+// client handle always give 0.
+//export HioRespFromCache_c
+func HioRespFromCache_c(handle C.longlong) C.int {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return boolResult(resp.fromCache)
+}
+
+// Returns the milliseconds between sending the request and receiving the
+// response headers.
+//export HioRespDurationMs_c
+func HioRespDurationMs_c(handle C.longlong) C.longlong {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.longlong(resp.elapsed / time.Millisecond)
+}
+
+// Like HioRespDurationMs_c but including the time taken to read the body.
+// Returns -1 for a streamed response, whose body is read by the caller.
+//export HioRespTotalDurationMs_c
+func HioRespTotalDurationMs_c(handle C.longlong) C.longlong {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    if resp.total < 0 {
+        return -1
+    }
+    return C.longlong(resp.total / time.Millisecond)
+}
+
+// Returns the media type without parameters, e.g. "text/html" for
+// "text/html; charset=utf-8", or an empty string when none was sent.
+//export HioRespContentType_c
+func HioRespContentType_c(handle C.longlong) *C.char {
+    resp, err := lookupResponse(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    contentType := resp.header.Get("Content-Type")
+    if contentType == "" {
+        return stringResult("", nil)
+    }
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    return stringResult(mediaType, err)
+}
+
+//export HioRespFree_c
+func HioRespFree_c(handle C.longlong) {
+    resp, err := lookupResponse(handle)
+    setLastError(err)
+    if err == nil && freeHandle("response", handle) != nil && resp.closer != nil {
+        resp.closer.Close()
+    }
+}
+
+//export HioClientNew_c
+func HioClientNew_c(timeoutMs C.longlong) C.longlong {
+    setLastError(nil)
+    return storeHandle(newClient(timeoutMs))
+}
+
+// A session client keeps the cookies set by its responses and sends them
+// back on later requests, with the same 10s timeout as the plain calls.
+//export HioClientNewSession_c
+func HioClientNewSession_c() C.longlong {
+    jar, err := cookiejar.New(nil)
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    c := newClient(C.longlong(sharedClient.Timeout / time.Millisecond))
+    c.http.Jar = jar
+    return storeHandle(c)
+}
+
+// Returns the value of the named cookie the client would send to url, or
+// an empty string when there is none.
+//export HioClientGetCookie_c
+func HioClientGetCookie_c(client C.longlong, url *C.char, name *C.char) *C.char {
+    c, err := lookupClient(client)
+    if err != nil {
+        return stringResult("", err)
+    }
+    if c.http.Jar == nil {
+        return stringResult("", fmt.Errorf("client %d is not a session client", client))
+    }
+    u, err := neturl.Parse(C.GoString(url))
+    if err != nil {
+        return stringResult("", err)
+    }
+
+    goName := C.GoString(name)
+    for _, cookie := range c.http.Jar.Cookies(u) {
+        if cookie.Name == goName {
+            return stringResult(cookie.Value, nil)
+        }
+    }
+    return stringResult("", nil)
+}
+
+//export HioClientGet_c
+func HioClientGet_c(client C.longlong, url *C.char) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return clientFetch(client, req)
+}
+
+//export HioClientPost_c
+func HioClientPost_c(client C.longlong, url *C.char, data *C.char) *C.char {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), "application/json")
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return clientFetch(client, req)
+}
+
+//export HioClientRequest_c
+func HioClientRequest_c(client C.longlong, method *C.char, url *C.char, body *C.char) *C.char {
+    req, err := newRequest(C.GoString(method), C.GoString(url), C.GoString(body))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return clientFetch(client, req)
+}
+
+// Like HioHttpGetResp_c, but sent through the client, so its settings and
+// cache apply. Returns the response handle, or 0 on error.
+//export HioClientGetResp_c
+func HioClientGetResp_c(client C.longlong, url *C.char) C.longlong {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return responseResult(nil, err)
+    }
+
+    return clientResponse(client, req)
+}
+
+// A context handle lets requests be aborted from another thread. Once
+// cancelled it stays cancelled, so every later request using it fails at
+// once.
+//export HioContextNew_c
+func HioContextNew_c() C.longlong {
+    setLastError(nil)
+    ctx, cancel := context.WithCancel(context.Background())
+    return storeHandle(&cancelToken{ctx: ctx, cancel: cancel})
+}
+
+// Aborts every in-flight request made with ctx.
+//export HioContextCancel_c
+func HioContextCancel_c(ctx C.longlong) {
+    token, err := lookupContext(ctx)
+    setLastError(err)
+    if err != nil {
+        return
+    }
+    token.cancel()
+}
+
+// Cancels ctx and releases the handle.
+//export HioContextFree_c
+func HioContextFree_c(ctx C.longlong) {
+    token, err := lookupContext(ctx)
+    setLastError(err)
+    if err == nil && freeHandle("context", ctx) != nil {
+        token.cancel()
+    }
+}
+
+//export HioClientGetCtx_c
+func HioClientGetCtx_c(client C.longlong, url *C.char, ctx C.longlong) *C.char {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return clientFetchCtx(client, req, ctx)
+}
+
+//export HioClientPostCtx_c
+func HioClientPostCtx_c(client C.longlong, url *C.char, data *C.char, ctx C.longlong) *C.char {
+    req, err := newPostRequest(C.GoString(url), C.GoString(data), "application/json")
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return clientFetchCtx(client, req, ctx)
+}
+
+//export HioClientRequestCtx_c
+func HioClientRequestCtx_c(client C.longlong, method *C.char, url *C.char, body *C.char, ctx C.longlong) *C.char {
+    req, err := newRequest(C.GoString(method), C.GoString(url), C.GoString(body))
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+
+    return clientFetchCtx(client, req, ctx)
+}
+
+//export HioClientSetMaxIdleConns_c
+func HioClientSetMaxIdleConns_c(client C.longlong, n C.int) C.int {
+    return configureClient(client, func(t *http.Transport) {
+        t.MaxIdleConns = int(n)
+    })
+}
+
+//export HioClientSetIdleConnTimeout_c
+func HioClientSetIdleConnTimeout_c(client C.longlong, timeoutMs C.longlong) C.int {
+    return configureClient(client, func(t *http.Transport) {
+        t.IdleConnTimeout = millis(timeoutMs)
+    })
+}
+
+// Limits the client to requestsPerSec requests per second on average,
+// allowing bursts of up to burst requests. Requests over the limit wait
+// for their turn, within the client's timeout. A rate of 0 removes the
+// limit. Returns 0 on success or -1 for an unknown client.
+//export HioClientSetRateLimit_c
+func HioClientSetRateLimit_c(client C.longlong, requestsPerSec C.double, burst C.int) C.int {
+    c, err := lookupClient(client)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    c.limiter.set(float64(requestsPerSec), int(burst))
+    return 0
+}
+
+// Keeps the bodies of successful GET and HEAD responses in memory for
+// ttlMs and answers identical requests (same URL; other headers are not
+// compared) from there. At most maxEntries are held, the oldest making way
+// for new ones. Responses marked Cache-Control: no-store or larger than
+// HioSetMaxResponseBytes_c allows are not kept. Requests so marked, and
+// those carrying an Authorization or Cookie header (including cookies from
+// a session client), skip the cache. A maxEntries of 0 turns caching off.
+// Changing the settings empties the cache. Returns 0 on success or -1 for
+// an unknown client.
+//export HioClientEnableCache_c
+func HioClientEnableCache_c(client C.longlong, maxEntries C.int, ttlMs C.longlong) C.int {
+    c, err := lookupClient(client)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    c.cache.configure(int(maxEntries), millis(ttlMs))
+    return 0
+}
+
+//export HioClientClearCache_c
+func HioClientClearCache_c(client C.longlong) C.int {
+    c, err := lookupClient(client)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    c.cache.clear()
+    return 0
+}
+
+// Bounds how long the client waits to establish a connection, separately
+// from the whole-request timeout. 0 leaves it to the operating system.
+//export HioClientSetConnectTimeout_c
+func HioClientSetConnectTimeout_c(client C.longlong, timeoutMs C.longlong) C.int {
+    c, err := lookupClient(client)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    c.connectTimeout = millis(timeoutMs)
+    c.setDialer()
+    return 0
+}
+
+// Bounds the wait for the response headers once the request has been
+// sent; reading the body is not limited by it. 0 means no limit.
+//export HioClientSetResponseHeaderTimeout_c
+func HioClientSetResponseHeaderTimeout_c(client C.longlong, timeoutMs C.longlong) C.int {
+    return configureClient(client, func(t *http.Transport) {
+        t.ResponseHeaderTimeout = millis(timeoutMs)
+    })
+}
+
+// Caps the connections, active and idle, the client keeps open to any one
+// host; further requests wait for one to free up. 0 means no limit.
+//export HioClientSetMaxConnsPerHost_c
+func HioClientSetMaxConnsPerHost_c(client C.longlong, n C.int) C.int {
+    if n < 0 {
+        n = 0
+    }
+    return configureClient(client, func(t *http.Transport) {
+        t.MaxConnsPerHost = int(n)
+    })
+}
+
+// Sets how many idle connections per host the client keeps for reuse.
+// 0 restores Go's default of 2.
+//export HioClientSetMaxIdleConnsPerHost_c
+func HioClientSetMaxIdleConnsPerHost_c(client C.longlong, n C.int) C.int {
+    if n < 0 {
+        n = 0
+    }
+    return configureClient(client, func(t *http.Transport) {
+        t.MaxIdleConnsPerHost = int(n)
+        // The pool-wide cap would otherwise undercut a large per-host one.
+        if t.MaxIdleConns != 0 && t.MaxIdleConns < t.MaxIdleConnsPerHost {
+            t.MaxIdleConns = t.MaxIdleConnsPerHost
+        }
+    })
+}
+
+// Sends all of the client's requests over the Unix domain socket at path,
+// e.g. /var/run/docker.sock, instead of to the host in the URL, which only
+// fills in the Host header: http://localhost/v1.43/info. Proxies are turned
+// off. Calling it again switches to the new path; the connect timeout
+// applies either way. Returns 0 on success or -1 on failure.
+//export HioClientSetUnixSocket_c
+func HioClientSetUnixSocket_c(client C.longlong, path *C.char) C.int {
+    goPath := C.GoString(path)
+    if goPath == "" {
+        setLastError(errors.New("empty socket path"))
+        return hioErrFailed
+    }
+    c, err := lookupClient(client)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    c.socketPath = goPath
+    // Connections already pooled lead to the old socket.
+    c.transport.CloseIdleConnections()
+    c.setDialer()
+    return 0
+}
+
+// Routes the client's requests through proxyUrl, which may use the http,
+// https or socks5 scheme. An empty string sends requests directly. Clients
+// start out honouring HTTP_PROXY, HTTPS_PROXY and NO_PROXY. Returns 0 on
+// success or -1 on failure.
+//export HioClientSetProxy_c
+func HioClientSetProxy_c(client C.longlong, proxyUrl *C.char) C.int {
+    raw := C.GoString(proxyUrl)
+    var proxy func(*http.Request) (*neturl.URL, error)
+    if raw != "" {
+        u, err := neturl.Parse(raw)
+        if err == nil && u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+            err = fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+        }
+        if err != nil {
+            setLastError(err)
+            return hioErrFailed
+        }
+        proxy = http.ProxyURL(u)
+    }
+
+    return configureClient(client, func(t *http.Transport) {
+        t.Proxy = proxy
+    })
+}
+
+// A non-zero skip turns off certificate verification for this client.
+// Only meant for servers with self-signed certificates under the caller's
+// control.
+//export HioClientSetInsecure_c
+func HioClientSetInsecure_c(client C.longlong, skip C.int) C.int {
+    return configureClient(client, func(t *http.Transport) {
+        tlsConfig(t).InsecureSkipVerify = skip != 0
+    })
+}
+
+// Trusts the certificates in the PEM file at pemPath. The first call
+// replaces the system roots, so a client can be pinned to its own CA;
+// further calls add to the set. Returns 0 on success, -4 if the file cannot
+// be read and -1 otherwise.
+//export HioClientAddCaCert_c
+func HioClientAddCaCert_c(client C.longlong, pemPath *C.char) C.int {
+    c, err := lookupClient(client)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+    pem, err := ioutil.ReadFile(C.GoString(pemPath))
+    if err != nil {
+        setLastError(err)
+        return hioErrFile
+    }
+
+    config := tlsConfig(c.transport)
+    pool := config.RootCAs
+    if pool == nil {
+        pool = x509.NewCertPool()
+    }
+    if !pool.AppendCertsFromPEM(pem) {
+        setLastError(fmt.Errorf("no certificates found in %s", C.GoString(pemPath)))
+        return hioErrFailed
+    }
+    config.RootCAs = pool
+    setLastError(nil)
+    return 0
+}
+
+// Presents the given certificate and key, both PEM files, to servers that
+// ask for one. Returns 0 on success, -4 if the pair cannot be loaded and -1
+// for an unknown client.
+//export HioClientSetClientCert_c
+func HioClientSetClientCert_c(client C.longlong, certPath *C.char, keyPath *C.char) C.int {
+    c, err := lookupClient(client)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+    cert, err := tls.LoadX509KeyPair(C.GoString(certPath), C.GoString(keyPath))
+    if err != nil {
+        setLastError(err)
+        return hioErrFile
+    }
+
+    tlsConfig(c.transport).Certificates = []tls.Certificate{cert}
+    setLastError(nil)
+    return 0
+}
+
+//export HioClientFree_c
+func HioClientFree_c(client C.longlong) {
+    c, err := lookupClient(client)
+    setLastError(err)
+    if err == nil && freeHandle("client", client) != nil {
+        c.transport.CloseIdleConnections()
+    }
+}
+
+// Returns 0 on success, -1 if the request failed, -2 on timeout, -3 for a
+// non-2xx status and -4 if the destination file could not be created.
+//export HioHttpDownload_c
+func HioHttpDownload_c(url *C.char, destPath *C.char) C.int {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+
+    return download(req, C.GoString(destPath), nil)
+}
+
+// Like HioHttpDownload_c but reads the body no faster than bytesPerSec,
+// so a background fetch leaves bandwidth for other traffic. 0 means no
+// limit.
+//export HioHttpDownloadThrottled_c
+func HioHttpDownloadThrottled_c(url *C.char, destPath *C.char, bytesPerSec C.longlong) C.int {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+
+    if bytesPerSec <= 0 {
+        return download(req, C.GoString(destPath), nil)
+    }
+    return download(req, C.GoString(destPath), func(resp *http.Response) io.Reader {
+        return newThrottledReader(req.Context(), resp.Body, int64(bytesPerSec))
+    })
+}
+
+// Like HioHttpDownload_c but calls callback, a C function of type
+// void (*)(long long done, long long total), as the body arrives: after
+// each megabyte or 100ms, whichever comes first, and once more when the
+// download completes. total is the Content-Length, or -1 when the server
+// sent none. The callback runs on the calling thread, before this returns.
+//export HioHttpDownloadProgress_c
+func HioHttpDownloadProgress_c(url *C.char, destPath *C.char, callback C.uintptr_t) C.int {
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+    if callback == 0 {
+        return download(req, C.GoString(destPath), nil)
+    }
+    return download(req, C.GoString(destPath), func(resp *http.Response) io.Reader {
+        return &progressReader{r: resp.Body, total: resp.ContentLength, callback: callback, last: time.Now()}
+    })
+}
+
+// Downloads bytes start to end inclusive (end < 0 for the rest of the
+// body) and writes them into destPath at offset start, creating the file
+// if needed. Passing the current file length as start resumes a download.
+// Returns the codes of HioHttpDownload_c, plus -6 if the server's answer
+// does not start at start, e.g. because it ignored the Range header and
+// sent the whole body; the file is left untouched then.
+//export HioHttpDownloadRange_c
+func HioHttpDownloadRange_c(url *C.char, destPath *C.char, start C.longlong, end C.longlong) C.int {
+    if start < 0 || (end >= 0 && end < start) {
+        setLastError(fmt.Errorf("invalid range %d-%d", start, end))
+        return hioErrFailed
+    }
+    req, err := http.NewRequest("GET", C.GoString(url), nil)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+    if end < 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+    } else {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+    }
+    // Compression would make the byte offsets refer to the encoded body.
+    req.Header.Set("Accept-Encoding", "identity")
+
+    return downloadRange(req, C.GoString(destPath), int64(start))
+}
+
+//export HioHttpUploadFile_c
+func HioHttpUploadFile_c(url *C.char, fieldName *C.char, filePath *C.char) *C.char {
+    return upload(url, fieldName, filePath, nil)
+}
+
+// Sends the key/value pairs collected in a header handle as extra text
+// fields alongside the file.
+//export HioHttpUploadFileWithFields_c
+func HioHttpUploadFileWithFields_c(url *C.char, fieldName *C.char, filePath *C.char, fields C.longlong) *C.char {
+    header, err := lookupHeader(fields)
+    if err != nil {
+        return bodyResult(nil, err)
+    }
+    viewValue(func() { header = header.Clone() })
+
+    return upload(url, fieldName, filePath, header)
+}
+
+//export HioReadFile_c
+func HioReadFile_c(path *C.char, outLen *C.int) *C.char {
+    data, err := ioutil.ReadFile(C.GoString(path))
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(data, outLen, nil)
+}
+
+// Creates or truncates the file at path. Returns 0 on success or -4 on
+// failure.
+//export HioWriteFile_c
+func HioWriteFile_c(path *C.char, data *C.char, length C.int) C.int {
+    return writeFile(C.GoString(path), os.O_TRUNC, goBytes(data, length))
+}
+
+// Like HioWriteFile_c, but adds to the end of an existing file.
+//export HioAppendFile_c
+func HioAppendFile_c(path *C.char, data *C.char, length C.int) C.int {
+    return writeFile(C.GoString(path), os.O_APPEND, goBytes(data, length))
+}
+
+//export HioQueryNew_c
+func HioQueryNew_c() C.longlong {
+    setLastError(nil)
+    return storeHandle(neturl.Values{})
+}
+
+//export HioQueryAdd_c
+func HioQueryAdd_c(handle C.longlong, key *C.char, value *C.char) {
+    values, err := lookupQuery(handle)
+    if err != nil {
+        setLastError(err)
+        return
+    }
+    goKey, goValue := C.GoString(key), C.GoString(value)
+    editValue(func() { values.Add(goKey, goValue) })
+    setLastError(nil)
+}
+
+// Returns the percent-encoded query string, with keys sorted.
+//export HioQueryEncode_c
+func HioQueryEncode_c(handle C.longlong) *C.char {
+    values, err := lookupQuery(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    var encoded string
+    viewValue(func() { encoded = values.Encode() })
+    return stringResult(encoded, nil)
+}
+
+//export HioQueryFree_c
+func HioQueryFree_c(handle C.longlong) {
+    if _, err := lookupQuery(handle); err != nil {
+        setLastError(err)
+        return
+    }
+    freeHandle("query", handle)
+}
+
+// Parses an application/x-www-form-urlencoded body into a query handle,
+// readable with HioFormGet_c and released with HioQueryFree_c. Returns 0
+// if body is malformed.
+//export HioParseForm_c
+func HioParseForm_c(body *C.char) C.longlong {
+    values, err := neturl.ParseQuery(C.GoString(body))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(values)
+}
+
+// Returns the first value of key, or an empty string when it is absent.
+//export HioFormGet_c
+func HioFormGet_c(handle C.longlong, key *C.char) *C.char {
+    values, err := lookupQuery(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    goKey := C.GoString(key)
+    var value string
+    viewValue(func() { value = values.Get(goKey) })
+    return stringResult(value, nil)
+}
+
+//export HioUrlJoin_c
+func HioUrlJoin_c(base *C.char, query *C.char) *C.char {
+    return stringResult(joinURL(C.GoString(base), C.GoString(query)), nil)
+}
+
+//export HioUrlEncode_c
+func HioUrlEncode_c(s *C.char) *C.char {
+    return stringResult(neturl.QueryEscape(C.GoString(s)), nil)
+}
+
+// Malformed input is reported through HioLastError_c and returned as is.
+//export HioUrlDecode_c
+func HioUrlDecode_c(s *C.char) *C.char {
+    goS := C.GoString(s)
+    decoded, err := neturl.QueryUnescape(goS)
+    if err != nil {
+        return stringResult(goS, err)
+    }
+    return stringResult(decoded, nil)
+}
+
+// Escapes s for use as a single path segment, encoding spaces as %20
+// rather than "+".
+//export HioUrlPathEscape_c
+func HioUrlPathEscape_c(s *C.char) *C.char {
+    return stringResult(neturl.PathEscape(C.GoString(s)), nil)
+}
+
+// Returns a handle for reading the parts of url, or 0 if it cannot be
+// parsed.
+//export HioUrlParse_c
+func HioUrlParse_c(url *C.char) C.longlong {
+    u, err := neturl.Parse(C.GoString(url))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(u)
+}
+
+//export HioUrlScheme_c
+func HioUrlScheme_c(handle C.longlong) *C.char {
+    return urlPart(handle, func(u *neturl.URL) string { return u.Scheme })
+}
+
+// Returns the host without any port.
+//export HioUrlHost_c
+func HioUrlHost_c(handle C.longlong) *C.char {
+    return urlPart(handle, (*neturl.URL).Hostname)
+}
+
+// Returns the explicit port, or an empty string when the URL has none.
+//export HioUrlPort_c
+func HioUrlPort_c(handle C.longlong) *C.char {
+    return urlPart(handle, (*neturl.URL).Port)
+}
+
+// Returns the decoded path.
+//export HioUrlPath_c
+func HioUrlPath_c(handle C.longlong) *C.char {
+    return urlPart(handle, func(u *neturl.URL) string { return u.Path })
+}
+
+// Returns the query without the leading "?", still encoded.
+//export HioUrlRawQuery_c
+func HioUrlRawQuery_c(handle C.longlong) *C.char {
+    return urlPart(handle, func(u *neturl.URL) string { return u.RawQuery })
+}
+
+//export HioUrlFragment_c
+func HioUrlFragment_c(handle C.longlong) *C.char {
+    return urlPart(handle, func(u *neturl.URL) string { return u.Fragment })
+}
+
+//export HioUrlFree_c
+func HioUrlFree_c(handle C.longlong) {
+    if _, err := lookupURL(handle); err != nil {
+        setLastError(err)
+        return
+    }
+    freeHandle("URL", handle)
+}
+
+//export HioBase64Encode_c
+func HioBase64Encode_c(data *C.char, length C.int) *C.char {
+    return stringResult(base64.StdEncoding.EncodeToString(goBytes(data, length)), nil)
+}
+
+//export HioBase64Decode_c
+func HioBase64Decode_c(s *C.char, outLen *C.int) *C.char {
+    decoded, err := base64.StdEncoding.DecodeString(C.GoString(s))
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(decoded, outLen, nil)
+}
+
+// Uses the URL-safe alphabet without padding.
+//export HioBase64EncodeUrl_c
+func HioBase64EncodeUrl_c(data *C.char, length C.int) *C.char {
+    return stringResult(base64.RawURLEncoding.EncodeToString(goBytes(data, length)), nil)
+}
+
+// Accepts URL-safe input with or without padding.
+//export HioBase64DecodeUrl_c
+func HioBase64DecodeUrl_c(s *C.char, outLen *C.int) *C.char {
+    decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(C.GoString(s), "="))
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(decoded, outLen, nil)
+}
+
+// Encodes as lowercase hex.
+//export HioHexEncode_c
+func HioHexEncode_c(data *C.char, length C.int) *C.char {
+    return stringResult(hex.EncodeToString(goBytes(data, length)), nil)
+}
+
+// Accepts either case.
+//export HioHexDecode_c
+func HioHexDecode_c(s *C.char, outLen *C.int) *C.char {
+    decoded, err := hex.DecodeString(C.GoString(s))
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(decoded, outLen, nil)
+}
+
+//export HioGunzip_c
+func HioGunzip_c(data *C.char, length C.int, outLen *C.int) *C.char {
+    decoded, err := gunzip(goBytes(data, length), 0)
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(decoded, outLen, nil)
+}
+
+//export HioGzip_c
+func HioGzip_c(data *C.char, length C.int, outLen *C.int) *C.char {
+    encoded, err := gzipBytes(goBytes(data, length))
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(encoded, outLen, nil)
+}
+
+// Decodes quoted-printable text such as a MIME body part.
+//export HioQuotedPrintableDecode_c
+func HioQuotedPrintableDecode_c(s *C.char, outLen *C.int) *C.char {
+    decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(C.GoString(s))))
+    if err != nil {
+        return bytesResult(nil, outLen, err)
+    }
+    return bytesResult(decoded, outLen, nil)
+}
+
+//export HioMD5_c
+func HioMD5_c(data *C.char, length C.int) *C.char {
+    return stringResult(hexDigest(md5.New(), goBytes(data, length)), nil)
+}
+
+//export HioSHA1_c
+func HioSHA1_c(data *C.char, length C.int) *C.char {
+    return stringResult(hexDigest(sha1.New(), goBytes(data, length)), nil)
+}
+
+//export HioSHA256_c
+func HioSHA256_c(data *C.char, length C.int) *C.char {
+    return stringResult(hexDigest(sha256.New(), goBytes(data, length)), nil)
+}
+
+// algo is one of "md5", "sha1" or "sha256".
+//export HioHashFile_c
+func HioHashFile_c(path *C.char, algo *C.char) *C.char {
+    return stringResult(hashFile(C.GoString(path), C.GoString(algo)))
+}
+
+//export HioHmacSha256_c
+func HioHmacSha256_c(key *C.char, keyLen C.int, data *C.char, dataLen C.int) *C.char {
+    mac := hmac.New(sha256.New, goBytes(key, keyLen))
+    return stringResult(hexDigest(mac, goBytes(data, dataLen)), nil)
+}
+
+// Returns 1 when expectedHex is the HMAC-SHA256 of data, 0 otherwise. The
+// comparison takes constant time.
+//export HioHmacVerify_c
+func HioHmacVerify_c(key *C.char, keyLen C.int, data *C.char, dataLen C.int, expectedHex *C.char) C.int {
+    expected, err := hex.DecodeString(C.GoString(expectedHex))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+
+    mac := hmac.New(sha256.New, goBytes(key, keyLen))
+    mac.Write(goBytes(data, dataLen))
+    if hmac.Equal(mac.Sum(nil), expected) {
+        return 1
+    }
+    return 0
+}
+
+// Returns n bytes from the system's secure random source, or NULL if it
+// fails.
+//export HioRandomBytes_c
+func HioRandomBytes_c(n C.int) *C.char {
+    b, err := randomBytes(n)
+    setLastError(err)
+    if err != nil {
+        return nil
+    }
+    return C.CString(string(b))
+}
+
+// Returns n random bytes as unpadded URL-safe base64, or NULL if the
+// system's random source fails.
+//export HioRandomToken_c
+func HioRandomToken_c(n C.int) *C.char {
+    b, err := randomBytes(n)
+    setLastError(err)
+    if err != nil {
+        return nil
+    }
+    return C.CString(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// A timeout of 0 waits as long as the operating system allows.
+//export HioTcpConnect_c
+func HioTcpConnect_c(host *C.char, port C.int, timeoutMs C.longlong) C.longlong {
+    conn, err := net.DialTimeout("tcp", hostPort(host, port), millis(timeoutMs))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(&streamConn{conn})
+}
+
+// Connects to the Unix domain socket at path. The handle works with the
+// HioTcpSend_c, HioTcpRecv_c and HioTcpClose_c family; the TCP-only
+// options fail on it.
+//export HioUnixConnect_c
+func HioUnixConnect_c(path *C.char, timeoutMs C.longlong) C.longlong {
+    conn, err := net.DialTimeout("unix", C.GoString(path), millis(timeoutMs))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(&streamConn{conn})
+}
+
+// Connects, sends request, reads the reply until the server closes the
+// connection and closes it again: the exchange of WHOIS, finger and
+// similar protocols. request is sent as is, so it must carry whatever line
+// ending the protocol expects. timeoutMs bounds the whole exchange; on
+// timeout the text received so far is returned and HioLastError_c is set.
+//export HioTcpRequest_c
+func HioTcpRequest_c(host *C.char, port C.int, request *C.char, timeoutMs C.longlong) *C.char {
+    return bodyResult(tcpRequest(hostPort(host, port), []byte(C.GoString(request)), millis(timeoutMs)))
+}
+
+// Returns the number of bytes written, or -1 (-2 on timeout) on failure.
+//export HioTcpSend_c
+func HioTcpSend_c(handle C.longlong, data *C.char, length C.int) C.int {
+    conn, err := lookupConn(handle)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+
+    n, err := conn.Write(goBytes(data, length))
+    setLastError(err)
+    if err != nil {
+        return errorCode(err)
+    }
+    return C.int(n)
+}
+
+// Reads up to maxLen bytes; see recvResult for the meaning of *outLen.
+//export HioTcpRecv_c
+func HioTcpRecv_c(handle C.longlong, maxLen C.int, outLen *C.int) *C.char {
+    conn, err := lookupConn(handle)
+    if err != nil {
+        return recvResult(nil, 0, err, outLen)
+    }
+    return recv(conn, maxLen, outLen)
+}
+
+// Makes reads that are still waiting timeoutMs from now fail with -2 (see
+// HioTcpRecv_c). The deadline stays in force for later reads until it is
+// set again; 0 clears it.
+//export HioTcpSetReadDeadline_c
+func HioTcpSetReadDeadline_c(handle C.longlong, timeoutMs C.longlong) C.int {
+    return setConnDeadline(handle, timeoutMs, net.Conn.SetReadDeadline)
+}
+
+// The write-side counterpart of HioTcpSetReadDeadline_c; HioTcpSend_c
+// returns -2 once it passes.
+//export HioTcpSetWriteDeadline_c
+func HioTcpSetWriteDeadline_c(handle C.longlong, timeoutMs C.longlong) C.int {
+    return setConnDeadline(handle, timeoutMs, net.Conn.SetWriteDeadline)
+}
+
+// A non-zero enable sends small writes immediately instead of waiting to
+// coalesce them (TCP_NODELAY; Go's default for new connections).
+//export HioTcpSetNoDelay_c
+func HioTcpSetNoDelay_c(handle C.longlong, enable C.int) C.int {
+    return configureTCP(handle, func(tcp *net.TCPConn) error {
+        return tcp.SetNoDelay(enable != 0)
+    })
+}
+
+// Sends keep-alive probes every intervalMs while the connection is idle;
+// 0 turns them off.
+//export HioTcpSetKeepAlive_c
+func HioTcpSetKeepAlive_c(handle C.longlong, intervalMs C.longlong) C.int {
+    return configureTCP(handle, func(tcp *net.TCPConn) error {
+        if intervalMs <= 0 {
+            return tcp.SetKeepAlive(false)
+        }
+        if err := tcp.SetKeepAlive(true); err != nil {
+            return err
+        }
+        return tcp.SetKeepAlivePeriod(millis(intervalMs))
+    })
+}
+
+//export HioTcpClose_c
+func HioTcpClose_c(handle C.longlong) {
+    conn, err := lookupConn(handle)
+    setLastError(err)
+    if err == nil && freeHandle("connection", handle) != nil {
+        conn.Close()
+    }
+}
+
+// Probes whether host:port accepts TCP connections. Returns 1 if it does,
+// 0 if the connection was refused and -1 on timeout or any other error.
+//export HioPortOpen_c
+func HioPortOpen_c(host *C.char, port C.int, timeoutMs C.longlong) C.int {
+    conn, err := net.DialTimeout("tcp", hostPort(host, port), millis(timeoutMs))
+    if err == nil {
+        conn.Close()
+        setLastError(nil)
+        return 1
+    }
+    if isConnRefused(err) {
+        setLastError(nil)
+        return 0
+    }
+    setLastError(err)
+    return hioErrFailed
+}
+
+// Sends one ICMP echo request over IPv4 and returns the round-trip time in
+// milliseconds, or -1 on timeout or any other failure. A timeout of 0
+// waits forever.
+//
+// Raw ICMP sockets need root (or CAP_NET_RAW) on most systems. Without
+// them the unprivileged ICMP sockets of Linux and macOS are used instead;
+// on Linux those are limited to the groups in net.ipv4.ping_group_range.
+//export HioPing_c
+func HioPing_c(host *C.char, timeoutMs C.longlong) C.longlong {
+    rtt, err := ping(C.GoString(host), millis(timeoutMs))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.longlong(rtt / time.Millisecond)
+}
+
+// Port 0 picks a free port.
+//export HioTcpListen_c
+func HioTcpListen_c(host *C.char, port C.int) C.longlong {
+    listener, err := net.Listen("tcp", hostPort(host, port))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(listener.(*net.TCPListener))
+}
+
+// Waits for the next connection and returns a handle usable with the
+// HioTcp send/recv/close functions, or 0 on error or when timeoutMs
+// passes. A timeout of 0 waits forever.
+//export HioTcpAccept_c
+func HioTcpAccept_c(listener C.longlong, timeoutMs C.longlong) C.longlong {
+    l, err := lookupListener(listener)
+    if err != nil {
+        setLastError(err)
+        return 0
+    }
+
+    if err := l.SetDeadline(deadline(timeoutMs)); err != nil {
+        setLastError(err)
+        return 0
+    }
+
+    conn, err := l.Accept()
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(&streamConn{conn})
+}
+
+// Returns the port the listener is bound to, or -1 for an unknown handle.
+//export HioTcpListenerPort_c
+func HioTcpListenerPort_c(listener C.longlong) C.int {
+    l, err := lookupListener(listener)
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.int(l.Addr().(*net.TCPAddr).Port)
+}
+
+//export HioTcpListenerClose_c
+func HioTcpListenerClose_c(listener C.longlong) {
+    l, err := lookupListener(listener)
+    setLastError(err)
+    if err == nil && freeHandle("listener", listener) != nil {
+        l.Close()
+    }
+}
+
+// Opens a UDP socket on an ephemeral local port.
+//export HioUdpSocket_c
+func HioUdpSocket_c() C.longlong {
+    conn, err := net.ListenPacket("udp", ":0")
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(&packetConn{conn})
+}
+
+// Returns the number of bytes sent, or -1 (-2 on timeout) on failure.
+//export HioUdpSendTo_c
+func HioUdpSendTo_c(handle C.longlong, host *C.char, port C.int, data *C.char, length C.int) C.int {
+    conn, err := lookupPacketConn(handle)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+    addr, err := net.ResolveUDPAddr("udp", hostPort(host, port))
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+
+    n, err := conn.WriteTo(goBytes(data, length), addr)
+    setLastError(err)
+    if err != nil {
+        return errorCode(err)
+    }
+    return C.int(n)
+}
+
+// Receives one datagram of at most maxLen bytes. *outLen follows the
+// HioTcpRecv_c convention. On success the sender's address is stored in
+// *outHost, a string the caller must release with HioFree_c, and
+// *outPort; on failure *outHost is set to NULL.
+//export HioUdpRecvFrom_c
+func HioUdpRecvFrom_c(handle C.longlong, maxLen C.int, outLen *C.int, outHost **C.char, outPort *C.int) *C.char {
+    if outHost != nil {
+        *outHost = nil
+    }
+    conn, err := lookupPacketConn(handle)
+    if err != nil {
+        return recvResult(nil, 0, err, outLen)
+    }
+    if maxLen <= 0 {
+        return recvResult(nil, 0, fmt.Errorf("invalid receive length %d", maxLen), outLen)
+    }
+
+    buf := make([]byte, int(maxLen))
+    n, addr, err := conn.ReadFrom(buf)
+    if err != nil {
+        return recvResult(buf, 0, err, outLen)
+    }
+    if udpAddr, ok := addr.(*net.UDPAddr); ok {
+        if outHost != nil {
+            *outHost = C.CString(udpAddr.IP.String())
+        }
+        if outPort != nil {
+            *outPort = C.int(udpAddr.Port)
+        }
+    }
+    return recvResult(buf, n, nil, outLen)
+}
+
+//export HioUdpClose_c
+func HioUdpClose_c(handle C.longlong) {
+    conn, err := lookupPacketConn(handle)
+    setLastError(err)
+    if err == nil && freeHandle("UDP", handle) != nil {
+        conn.Close()
+    }
+}
+
+// Sends all later DNS lookups to serverAddr, e.g. "1.1.1.1:53" or
+// "2606:4700::1111" (port 53 by default). An empty string restores the
+// system resolver. HTTP requests and dials keep using the system resolver.
+//export HioDnsSetResolver_c
+func HioDnsSetResolver_c(serverAddr *C.char) {
+    r := net.DefaultResolver
+    if addr := C.GoString(serverAddr); addr != "" {
+        var err error
+        if r, err = newResolver(addr); err != nil {
+            setLastError(err)
+            return
+        }
+    }
+    resolver.Lock()
+    resolver.value = r
+    resolver.Unlock()
+    setLastError(nil)
+}
+
+// Returns the host's addresses as a comma-separated list. Failures are
+// reported as "dns not found: ..." or "dns timeout: ..." where possible.
+//export HioDnsLookup_c
+func HioDnsLookup_c(host *C.char) *C.char {
+    return stringResult(lookupIPs(C.GoString(host)))
+}
+
+//export HioDnsReverse_c
+func HioDnsReverse_c(ip *C.char) *C.char {
+    return stringResult(lookupNames(C.GoString(ip)))
+}
+
+// TXT records may contain commas, so they are separated by newlines.
+//export HioDnsLookupTxt_c
+func HioDnsLookupTxt_c(host *C.char) *C.char {
+    return stringResult(lookupTXT(C.GoString(host)))
+}
+
+// Returns "preference host" entries as a comma-separated list.
+//export HioDnsLookupMx_c
+func HioDnsLookupMx_c(host *C.char) *C.char {
+    return stringResult(lookupMX(C.GoString(host)))
+}
+
+//export HioHostname_c
+func HioHostname_c() *C.char {
+    return stringResult(os.Hostname())
+}
+
+// Returns the value of the environment variable name, or an empty string
+// when it is unset.
+//export HioGetEnv_c
+func HioGetEnv_c(name *C.char) *C.char {
+    return stringResult(os.Getenv(C.GoString(name)), nil)
+}
+
+// Like HioGetEnv_c but returns fallback when name is unset. A variable set
+// to the empty string is returned as it is.
+//export HioGetEnvDefault_c
+func HioGetEnvDefault_c(name *C.char, fallback *C.char) *C.char {
+    if value, ok := os.LookupEnv(C.GoString(name)); ok {
+        return stringResult(value, nil)
+    }
+    return stringResult(C.GoString(fallback), nil)
+}
+
+// Returns 0 on success and -1 if name is empty or contains '=' or a NUL.
+// Proxy variables are read once, by the first request through them, so
+// HTTP_PROXY and friends must be set before any request is sent.
+//export HioSetEnv_c
+func HioSetEnv_c(name *C.char, value *C.char) C.int {
+    err := os.Setenv(C.GoString(name), C.GoString(value))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return 0
+}
+
+// Returns the non-loopback interface addresses, comma separated.
+//export HioLocalIPs_c
+func HioLocalIPs_c() *C.char {
+    return stringResult(localIPs())
+}
+
+// Returns the address of the interface used for outbound traffic.
+//export HioOutboundIP_c
+func HioOutboundIP_c() *C.char {
+    return stringResult(outboundIP())
+}
+
+//export HioIsValidIP_c
+func HioIsValidIP_c(s *C.char) C.int {
+    setLastError(nil)
+    return boolResult(net.ParseIP(C.GoString(s)) != nil)
+}
+
+//export HioIsIPv4_c
+func HioIsIPv4_c(s *C.char) C.int {
+    setLastError(nil)
+    ip := net.ParseIP(C.GoString(s))
+    return boolResult(ip != nil && ip.To4() != nil)
+}
+
+//export HioIsIPv6_c
+func HioIsIPv6_c(s *C.char) C.int {
+    setLastError(nil)
+    ip := net.ParseIP(C.GoString(s))
+    return boolResult(ip != nil && ip.To4() == nil)
+}
+
+// Reports whether s lies in a private range: 10/8, 172.16/12, 192.168/16
+// or fc00::/7.
+//export HioIsPrivateIP_c
+func HioIsPrivateIP_c(s *C.char) C.int {
+    setLastError(nil)
+    ip := net.ParseIP(C.GoString(s))
+    return boolResult(ip != nil && ip.IsPrivate())
+}
+
+// Reports whether ip lies inside cidr, e.g. "10.0.0.0/8". A malformed cidr
+// gives 0 and sets HioLastError_c.
+//export HioCidrContains_c
+func HioCidrContains_c(cidr *C.char, ip *C.char) C.int {
+    _, network, err := net.ParseCIDR(C.GoString(cidr))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return boolResult(network.Contains(net.ParseIP(C.GoString(ip))))
+}
+
+// Reports whether s is a syntactically valid ASCII hostname: labels of
+// letters, digits and inner hyphens, 63 characters at most, 253 in all. An
+// internationalized name must go through HioNormalizeHostname_c first.
+//export HioIsValidHostname_c
+func HioIsValidHostname_c(s *C.char) C.int {
+    setLastError(nil)
+    return boolResult(checkHostname(C.GoString(s)) == nil)
+}
+
+// Reports whether s is a bare email address such as "user@example.com".
+// Display names and angle brackets are rejected.
+//export HioIsValidEmail_c
+func HioIsValidEmail_c(s *C.char) C.int {
+    setLastError(nil)
+    goS := C.GoString(s)
+    addr, err := mail.ParseAddress(goS)
+    return boolResult(err == nil && addr.Name == "" && addr.Address == goS)
+}
+
+// Lowercases s and converts internationalized labels to punycode, e.g.
+// "Bücher.example" to "xn--bcher-kva.example". Only lowercasing is applied
+// before the conversion, not the full IDNA mapping. A result that is not a
+// valid hostname gives an empty string and sets HioLastError_c.
+//export HioNormalizeHostname_c
+func HioNormalizeHostname_c(s *C.char) *C.char {
+    host, err := normalizeHostname(C.GoString(s))
+    if err != nil {
+        return stringResult("", err)
+    }
+    return stringResult(host, nil)
+}
+
+// Looks up a dotted path such as "data.items.0.name", where numeric
+// segments index arrays. Missing paths and values of the wrong type give
+// an empty string and set HioLastError_c.
+//export HioJsonGetString_c
+func HioJsonGetString_c(json *C.char, path *C.char) *C.char {
+    return stringResult(jsonString(C.GoString(json), C.GoString(path)))
+}
+
+// Returns 0 and sets HioLastError_c unless the value is an integer.
+//export HioJsonGetInt_c
+func HioJsonGetInt_c(json *C.char, path *C.char) C.longlong {
+    goPath := C.GoString(path)
+    n, err := jsonNumber(C.GoString(json), goPath)
+    if err != nil {
+        setLastError(err)
+        return 0
+    }
+    i, err := n.Int64()
+    if err != nil {
+        setLastError(fmt.Errorf("json path %q: not an integer", goPath))
+        return 0
+    }
+    setLastError(nil)
+    return C.longlong(i)
+}
+
+//export HioJsonGetFloat_c
+func HioJsonGetFloat_c(json *C.char, path *C.char) C.double {
+    n, err := jsonNumber(C.GoString(json), C.GoString(path))
+    if err != nil {
+        setLastError(err)
+        return 0
+    }
+    f, err := n.Float64()
+    setLastError(err)
+    return C.double(f)
+}
+
+// Returns the length of the array at path, or -1 and sets HioLastError_c
+// if there is no array there.
+//export HioJsonArrayLen_c
+func HioJsonArrayLen_c(json *C.char, path *C.char) C.int {
+    array, err := jsonArray(C.GoString(json), C.GoString(path))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.int(len(array))
+}
+
+// Returns element index of the array at path re-encoded as JSON, so that
+// it can be passed back to the HioJsonGet functions. An index out of range
+// gives an empty string and sets HioLastError_c.
+//export HioJsonArrayGet_c
+func HioJsonArrayGet_c(json *C.char, path *C.char, index C.int) *C.char {
+    return stringResult(jsonElement(C.GoString(json), C.GoString(path), int(index)))
+}
+
+// Reformats json with one level of indent (two spaces when empty) per
+// nesting depth. Invalid JSON gives an empty string and sets
+// HioLastError_c, naming the byte offset of the error.
+//export HioJsonPretty_c
+func HioJsonPretty_c(json *C.char, indent *C.char) *C.char {
+    return stringResult(prettyJSON(C.GoString(json), C.GoString(indent)))
+}
+
+// Strips all insignificant whitespace from json.
+//export HioJsonMinify_c
+func HioJsonMinify_c(json *C.char) *C.char {
+    return stringResult(minifyJSON(C.GoString(json)))
+}
+
+//export HioJsonNew_c
+func HioJsonNew_c() C.longlong {
+    setLastError(nil)
+    return storeHandle(&jsonObject{values: make(map[string]interface{})})
+}
+
+//export HioJsonSetString_c
+func HioJsonSetString_c(handle C.longlong, key *C.char, value *C.char) {
+    jsonSet(handle, key, C.GoString(value))
+}
+
+//export HioJsonSetInt_c
+func HioJsonSetInt_c(handle C.longlong, key *C.char, value C.longlong) {
+    jsonSet(handle, key, int64(value))
+}
+
+// NaN and infinities have no JSON form and are rejected.
+//export HioJsonSetFloat_c
+func HioJsonSetFloat_c(handle C.longlong, key *C.char, value C.double) {
+    f := float64(value)
+    if math.IsNaN(f) || math.IsInf(f, 0) {
+        setLastError(fmt.Errorf("json: unsupported number %v", f))
+        return
+    }
+    jsonSet(handle, key, f)
+}
+
+//export HioJsonSetBool_c
+func HioJsonSetBool_c(handle C.longlong, key *C.char, value C.int) {
+    jsonSet(handle, key, value != 0)
+}
+
+// Nests a copy of child as it is now; later changes to child, or freeing
+// it, do not affect the parent.
+//export HioJsonSetObject_c
+func HioJsonSetObject_c(handle C.longlong, key *C.char, child C.longlong) {
+    obj, err := lookupJSON(child)
+    if err != nil {
+        setLastError(err)
+        return
+    }
+    var encoded []byte
+    viewValue(func() { encoded, err = json.Marshal(obj) })
+    if err != nil {
+        setLastError(err)
+        return
+    }
+    jsonSet(handle, key, json.RawMessage(encoded))
+}
+
+//export HioJsonEncode_c
+func HioJsonEncode_c(handle C.longlong) *C.char {
+    obj, err := lookupJSON(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    var encoded []byte
+    viewValue(func() { encoded, err = json.Marshal(obj) })
+    return bodyResult(encoded, err)
+}
+
+//export HioJsonFree_c
+func HioJsonFree_c(handle C.longlong) {
+    if _, err := lookupJSON(handle); err != nil {
+        setLastError(err)
+        return
+    }
+    freeHandle("JSON", handle)
+}
+
+// url uses the ws:// or wss:// scheme. Returns 0 on error.
+//export HioWsConnect_c
+func HioWsConnect_c(url *C.char) C.longlong {
+    ws, err := dialWebSocket(C.GoString(url))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(ws)
+}
+
+// Returns 0 on success or -1 on failure.
+//export HioWsSendText_c
+func HioWsSendText_c(handle C.longlong, msg *C.char) C.int {
+    return wsSend(handle, wsOpText, []byte(C.GoString(msg)))
+}
+
+//export HioWsSendBinary_c
+func HioWsSendBinary_c(handle C.longlong, data *C.char, length C.int) C.int {
+    return wsSend(handle, wsOpBinary, goBytes(data, length))
+}
+
+func wsSend(handle C.longlong, opcode byte, payload []byte) C.int {
+    ws, err := lookupWebSocket(handle)
+    if err == nil {
+        err = ws.writeFrame(opcode, payload)
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return 0
+}
+
+// Waits up to timeoutMs (0 waits forever) for the next text or binary
+// message. *outLen receives the message length, or -2 on timeout, -5 once
+// the server has closed the connection and -1 on any other error.
+//export HioWsRecv_c
+func HioWsRecv_c(handle C.longlong, timeoutMs C.longlong, outLen *C.int) *C.char {
+    ws, err := lookupWebSocket(handle)
+    if err != nil {
+        return recvResult(nil, 0, err, outLen)
+    }
+
+    ws.readMu.Lock()
+    ws.conn.SetReadDeadline(deadline(timeoutMs))
+    message, err := ws.readMessage()
+    ws.readMu.Unlock()
+    if err == errWsClosed || err == io.EOF {
+        setLastError(errWsClosed)
+        if outLen != nil {
+            *outLen = hioErrClosed
+        }
+        return C.CString("")
+    }
+    return recvResult(message, len(message), err, outLen)
+}
+
+// Sends a normal-closure frame and releases the handle.
+//export HioWsClose_c
+func HioWsClose_c(handle C.longlong) {
+    ws, err := lookupWebSocket(handle)
+    setLastError(err)
+    if err == nil && freeHandle("WebSocket", handle) != nil {
+        ws.Close()
+    }
+}
+
+// Subscribes to the Server-Sent Events stream at url. Returns 0 on error,
+// including a non-2xx status.
+//export HioSseConnect_c
+func HioSseConnect_c(url *C.char) C.longlong {
+    stream, err := dialSSE(C.GoString(url))
+    setLastError(err)
+    if err != nil {
+        return 0
+    }
+    return storeHandle(stream)
+}
+
+// Waits up to timeoutMs (0 for no limit) for the next event and returns
+// its data. On timeout, and once the stream has ended, it returns an empty
+// string and sets HioLastError_c; an event with empty data leaves the
+// error clear.
+//export HioSseNext_c
+func HioSseNext_c(handle C.longlong, timeoutMs C.longlong) *C.char {
+    stream, err := lookupSSE(handle)
+    if err != nil {
+        return stringResult("", err)
+    }
+    return stringResult(stream.next(millis(timeoutMs)))
+}
+
+// Closes the connection and releases the handle.
+//export HioSseClose_c
+func HioSseClose_c(handle C.longlong) {
+    stream, err := lookupSSE(handle)
+    setLastError(err)
+    if err == nil && freeHandle("SSE", handle) != nil {
+        stream.Close()
+    }
+}
+
+// Creates an HTTP server that will listen on host:port (port 0 picks a
+// free one) once started.
+//export HioServerNew_c
+func HioServerNew_c(host *C.char, port C.int) C.longlong {
+    setLastError(nil)
+    mux := http.NewServeMux()
+    return storeHandle(&httpServer{
+        server: &http.Server{Addr: hostPort(host, port), Handler: mux},
+        mux:    mux,
+    })
+}
+
+// Routes requests for path, a net/http ServeMux pattern, to callback, a
+// C function of type char* (*)(const char* body). It is called on a
+// library thread with the request body and returns the response body,
+// which must be allocated with malloc (the library frees it) or be NULL
+// for an empty reply. Request bodies over 16 MiB are answered with 413
+// without calling it. Routes may be added before or after the server
+// starts. Returns 0 on success or -1 on failure.
+//export HioServerHandle_c
+func HioServerHandle_c(server C.longlong, path *C.char, callback C.uintptr_t) C.int {
+    if callback == 0 {
+        setLastError(errors.New("callback is NULL"))
+        return hioErrFailed
+    }
+    srv, err := lookupServer(server)
+    if err == nil {
+        err = srv.route(C.GoString(path), callback)
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return 0
+}
+
+// Starts listening and serving in the background. Returns 0 on success or
+// -1 on failure, e.g. when the address is in use.
+//export HioServerStart_c
+func HioServerStart_c(server C.longlong) C.int {
+    srv, err := lookupServer(server)
+    if err == nil {
+        err = srv.start()
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return 0
+}
+
+// Returns the port the server listens on, or -1 if it is not started.
+//export HioServerPort_c
+func HioServerPort_c(server C.longlong) C.int {
+    srv, err := lookupServer(server)
+    port := 0
+    if err == nil {
+        port, err = srv.port()
+    }
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.int(port)
+}
+
+// Stops accepting connections, waits up to five seconds for requests in
+// progress to finish and releases the handle.
+//export HioServerStop_c
+func HioServerStop_c(server C.longlong) {
+    srv, err := lookupServer(server)
+    setLastError(err)
+    if err != nil || freeHandle("server", server) == nil {
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+    defer cancel()
+    if err := srv.server.Shutdown(ctx); err != nil {
+        srv.server.Close()
+    }
+}
+
+//export HioGetTimestamp_c
+func HioGetTimestamp_c() C.longlong {
+    setLastError(nil)
+    return C.longlong(time.Now().Unix())
+}
+
+// layout is a Go layout string or one of "rfc3339", "iso8601", "unixdate"
+// and "rfc1123". The time is shown in the local time zone.
+//export HioFormatTime_c
+func HioFormatTime_c(unixSec C.longlong, layout *C.char) *C.char {
+    t := time.Unix(int64(unixSec), 0)
+    return stringResult(t.Format(resolveLayout(C.GoString(layout))), nil)
+}
+
+//export HioFormatTimeUtc_c
+func HioFormatTimeUtc_c(unixSec C.longlong, layout *C.char) *C.char {
+    t := time.Unix(int64(unixSec), 0).UTC()
+    return stringResult(t.Format(resolveLayout(C.GoString(layout))), nil)
+}
+
+// Accepts the same layouts as HioFormatTime_c; values without a zone are
+// taken as UTC. Returns -1 on failure, which is also the valid timestamp
+// of 1969-12-31T23:59:59Z, so check HioLastError_c when in doubt.
+//export HioParseTime_c
+func HioParseTime_c(value *C.char, layout *C.char) C.longlong {
+    t, err := time.Parse(resolveLayout(C.GoString(layout)), C.GoString(value))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.longlong(t.Unix())
+}
+
+//export HioParseTimeRfc3339_c
+func HioParseTimeRfc3339_c(value *C.char) C.longlong {
+    t, err := time.Parse(time.RFC3339, C.GoString(value))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.longlong(t.Unix())
+}
+
+//export HioGetTimestampMs_c
+func HioGetTimestampMs_c() C.longlong {
+    setLastError(nil)
+    return C.longlong(time.Now().UnixMilli())
+}
+
+//export HioGetTimestampNs_c
+func HioGetTimestampNs_c() C.longlong {
+    setLastError(nil)
+    return C.longlong(time.Now().UnixNano())
+}
+
+// Nanoseconds since the library was loaded, from the monotonic clock.
+// Only differences between two readings are meaningful; they are not
+// affected by wall-clock adjustments.
+//export HioMonotonicNs_c
+func HioMonotonicNs_c() C.longlong {
+    setLastError(nil)
+    return C.longlong(time.Since(processStart).Nanoseconds())
+}
+
+// Asks an NTP server (pool.ntp.org when server is empty) for the time and
+// returns it as Unix milliseconds, or -1 on failure. server may carry a
+// port; 123 is assumed otherwise.
+//export HioNtpTime_c
+func HioNtpTime_c(server *C.char) C.longlong {
+    t, err := ntpTime(C.GoString(server))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    return C.longlong(t.UnixNano() / int64(time.Millisecond))
+}
+
+//export HioSleep_c
+func HioSleep_c(ms C.longlong) {
+    setLastError(nil)
+    time.Sleep(time.Duration(ms) * time.Millisecond)
+}
+
+// Sleeps for ms milliseconds unless ctx (see HioContextNew_c) is cancelled
+// or freed first. Returns 0 after a full sleep, -7 if woken by the context
+// (at once if it was already cancelled) and -1 for an invalid handle.
+//export HioSleepCtx_c
+func HioSleepCtx_c(ms C.longlong, ctx C.longlong) C.int {
+    token, err := lookupContext(ctx)
+    if err != nil {
+        setLastError(err)
+        return hioErrFailed
+    }
+    wait := time.NewTimer(millis(ms))
+    defer wait.Stop()
+    select {
+    case <-wait.C:
+        setLastError(nil)
+        return 0
+    case <-token.ctx.Done():
+        setLastError(token.ctx.Err())
+        return hioErrCancelled
+    }
+}
+
+// Calls callback, a C function of type void (*)(long long timer), once
+// after delayMs. It runs on a library thread, alongside the thread that
+// scheduled it, and may call any function of this library. Returns the
+// timer handle, which is released once the callback has run.
+//export HioSetTimeout_c
+func HioSetTimeout_c(delayMs C.longlong, callback C.uintptr_t) C.longlong {
+    if callback == 0 {
+        setLastError(errors.New("callback is NULL"))
+        return 0
+    }
+    setLastError(nil)
+    return startTimer(millis(delayMs), 0, callback)
+}
+
+// Like HioSetTimeout_c but calls callback every periodMs (which must be
+// positive) until the timer is cleared. The next period starts once the
+// callback returns, so slow callbacks never overlap.
+//export HioSetInterval_c
+func HioSetInterval_c(periodMs C.longlong, callback C.uintptr_t) C.longlong {
+    if callback == 0 || periodMs <= 0 {
+        setLastError(errors.New("interval needs a callback and a positive period"))
+        return 0
+    }
+    setLastError(nil)
+    return startTimer(millis(periodMs), millis(periodMs), callback)
+}
+
+// Cancels a timer and releases its handle. A callback already running is
+// not interrupted, but the timer will not fire again; clearing an interval
+// from inside its own callback is allowed.
+//export HioClearTimer_c
+func HioClearTimer_c(handle C.longlong) {
+    t, err := lookupTimer(handle)
+    setLastError(err)
+    if err == nil && freeHandle("timer", handle) != nil {
+        t.Close()
+    }
+}
+
+// Calls callback, a C function of type void (*)(int sig), on a library
+// thread each time the process receives SIGINT or SIGTERM. While any
+// callback is registered those signals no longer end the process, so the
+// callback should clean up (HioNetCleanup_c closes every connection) and
+// exit itself. Returns a signal handle for HioOffSignal_c, or 0 on error.
+//export HioOnSignal_c
+func HioOnSignal_c(callback C.uintptr_t) C.longlong {
+    if callback == 0 {
+        setLastError(errors.New("callback is NULL"))
+        return 0
+    }
+    setLastError(nil)
+    return storeHandle(watchSignals(callback))
+}
+
+// Unregisters a callback added by HioOnSignal_c and releases its handle.
+// Once no callbacks remain, SIGINT and SIGTERM end the process again.
+//export HioOffSignal_c
+func HioOffSignal_c(handle C.longlong) {
+    w, err := lookupSignalWatcher(handle)
+    setLastError(err)
+    if err == nil && freeHandle("signal", handle) != nil {
+        w.Close()
+    }
+}
+
+// Makes the process ignore signame ("SIGINT", "TERM", also SIGHUP and
+// SIGQUIT, case-insensitive). A later HioOnSignal_c for the same signal
+// takes it back. Returns 0 on success and -1 for an unknown name.
+//export HioIgnoreSignal_c
+func HioIgnoreSignal_c(signame *C.char) C.int {
+    sig, err := parseSignal(C.GoString(signame))
+    setLastError(err)
+    if err != nil {
+        return hioErrFailed
+    }
+    signal.Ignore(sig)
+    return 0
 }
 
 //-------------------------
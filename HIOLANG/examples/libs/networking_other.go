@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+    "errors"
+    "syscall"
+)
+
+// isConnRefused reports whether err means nothing was listening on the
+// other end.
+func isConnRefused(err error) bool {
+    return errors.Is(err, syscall.ECONNREFUSED)
+}
\ No newline at end of file
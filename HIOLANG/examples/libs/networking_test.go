@@ -0,0 +1,300 @@
+package main
+
+import (
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "runtime"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// TestConcurrentHandles creates, uses and frees handles of several kinds
+// from many goroutines at once, sharing a header and a JSON object between
+// them. Run it with -race.
+func TestConcurrentHandles(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer srv.Close()
+
+    sharedHeader := HioHeaderNew_c()
+    defer HioHeaderFree_c(sharedHeader)
+    header, err := lookupHeader(sharedHeader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sharedJSON := HioJsonNew_c()
+    defer HioJsonFree_c(sharedJSON)
+    obj, err := lookupJSON(sharedJSON)
+    if err != nil {
+        t.Fatal(err)
+    }
+    client := HioClientNew_c(5000)
+    defer HioClientFree_c(client)
+
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            for n := 0; n < 25; n++ {
+                editValue(func() { header["X-Worker"] = append(header["X-Worker"], "v") })
+                editValue(func() { obj.set("n", n) })
+                viewValue(func() { obj.MarshalJSON() })
+
+                c, err := lookupClient(client)
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                req, _ := http.NewRequest("GET", srv.URL, nil)
+                viewValue(func() { applyHeaders(req, header) })
+                resp, err := c.http.Do(req)
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                ioutil.ReadAll(resp.Body)
+                resp.Body.Close()
+
+                ctx := HioContextNew_c()
+                HioContextCancel_c(ctx)
+                HioContextFree_c(ctx)
+
+                handle := storeHandle(&response{status: 200, body: []byte("a\nb\n")})
+                if status := HioRespStatus_c(handle); status != 200 {
+                    t.Errorf("status %d", status)
+                }
+                HioRespFree_c(handle)
+
+                HioQueryFree_c(HioQueryNew_c())
+                HioOpenHandleCount_c()
+                HioOpenConnCount_c()
+            }
+        }(i)
+    }
+    wg.Wait()
+}
+
+// TestFreedHandles checks that freeing a handle twice or using it after
+// Free reports an invalid handle and leaves every other handle alone.
+func TestFreedHandles(t *testing.T) {
+    // HioLastErrorCode_c reads per-thread state.
+    runtime.LockOSThread()
+    defer runtime.UnlockOSThread()
+
+    header := HioHeaderNew_c()
+    HioHeaderFree_c(header)
+    if code := HioLastErrorCode_c(); code != hioCodeNone {
+        t.Fatalf("first free: code %d", code)
+    }
+
+    // An id is never handed out again, so the stale one cannot reach the
+    // handle created after it.
+    other := HioHeaderNew_c()
+    defer HioHeaderFree_c(other)
+    if other == header {
+        t.Fatal("freed id reused")
+    }
+    HioHeaderFree_c(header)
+    if code := HioLastErrorCode_c(); code != hioCodeInvalidHandle {
+        t.Fatalf("double free: code %d", code)
+    }
+    if _, err := lookupHeader(header); err == nil || !strings.Contains(err.Error(), "already been freed") {
+        t.Fatalf("double free: %v", err)
+    }
+    if _, err := lookupHeader(other); err != nil {
+        t.Fatalf("double free released another handle: %v", err)
+    }
+
+    resp := storeHandle(&response{status: 200})
+    HioRespFree_c(resp)
+    if status := HioRespStatus_c(resp); status != hioErrFailed {
+        t.Fatalf("use after free: status %d", status)
+    }
+    if code := HioLastErrorCode_c(); code != hioCodeInvalidHandle {
+        t.Fatalf("use after free: code %d", code)
+    }
+    HioRespFree_c(resp)
+    if code := HioLastErrorCode_c(); code != hioCodeInvalidHandle {
+        t.Fatalf("double free: code %d", code)
+    }
+    if _, err := lookupResponse(resp); err == nil || !strings.Contains(err.Error(), "already been freed") {
+        t.Fatalf("use after free: %v", err)
+    }
+
+    // Freeing through the wrong kind of Free must not release the handle.
+    obj := HioJsonNew_c()
+    defer HioJsonFree_c(obj)
+    HioHeaderFree_c(obj)
+    if code := HioLastErrorCode_c(); code != hioCodeInvalidHandle {
+        t.Fatalf("wrong kind: code %d", code)
+    }
+    if _, err := lookupHeader(obj); err == nil || !strings.Contains(err.Error(), "invalid header handle") {
+        t.Fatalf("wrong kind: %v", err)
+    }
+    if _, err := lookupJSON(obj); err != nil {
+        t.Fatalf("wrong-kind free released the handle: %v", err)
+    }
+
+    // Unknown ids are rejected without touching the registry.
+    before := HioOpenHandleCount_c()
+    for _, free := range []func(){
+        func() { HioClientFree_c(-1) },
+        func() { HioContextFree_c(0) },
+        func() { HioTcpClose_c(1 << 40) },
+    } {
+        free()
+        if code := HioLastErrorCode_c(); code != hioCodeInvalidHandle {
+            t.Fatalf("unknown handle: code %d", code)
+        }
+    }
+    if after := HioOpenHandleCount_c(); after != before {
+        t.Fatalf("unknown handles changed the count from %d to %d", before, after)
+    }
+}
+
+
+// TestConcurrentFree frees the same handle from several threads at once;
+// exactly one of them may succeed.
+func TestConcurrentFree(t *testing.T) {
+    // The interleaving that matters, played out on one thread: another
+    // thread releases the handle between our lookup and our release.
+    runtime.LockOSThread()
+    stale := HioHeaderNew_c()
+    if _, err := lookupHeader(stale); err != nil {
+        t.Fatal(err)
+    }
+    releaseHandle(stale)
+    if freeHandle("header", stale) != nil || HioLastErrorCode_c() != hioCodeInvalidHandle {
+        t.Fatal("losing free reported success")
+    }
+    runtime.UnlockOSThread()
+
+    for round := 0; round < 200; round++ {
+        header := HioHeaderNew_c()
+        if n := racingFrees(func() { HioHeaderFree_c(header) }); n != 1 {
+            t.Fatalf("header freed %d times", n)
+        }
+        resp := storeHandle(&response{status: 200})
+        if n := racingFrees(func() { HioRespFree_c(resp) }); n != 1 {
+            t.Fatalf("response freed %d times", n)
+        }
+    }
+}
+
+// racingFrees runs free on eight threads at the same moment and returns
+// how many of the calls reported success.
+func racingFrees(free func()) int {
+    var wg sync.WaitGroup
+    var ok int32
+    start := make(chan struct{})
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            runtime.LockOSThread()
+            defer runtime.UnlockOSThread()
+            <-start
+            free()
+            if HioLastErrorCode_c() == hioCodeNone {
+                atomic.AddInt32(&ok, 1)
+            }
+        }()
+    }
+    close(start)
+    wg.Wait()
+    return int(ok)
+}
+
+// TestClientResponseFromCache fetches one URL twice through a client with
+// its cache on; only the second response may come from the cache.
+func TestClientResponseFromCache(t *testing.T) {
+    var hits int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&hits, 1)
+        w.Write([]byte("ok"))
+    }))
+    defer srv.Close()
+
+    client := HioClientNew_c(5000)
+    defer HioClientFree_c(client)
+    if HioClientEnableCache_c(client, 8, 60000) != 0 {
+        t.Fatal("enable cache")
+    }
+    for i, want := range []int{0, 1} {
+        req, _ := http.NewRequest("GET", srv.URL, nil)
+        resp := clientResponse(client, req)
+        if resp == 0 {
+            t.Fatalf("request %d failed", i)
+        }
+        if got := HioRespFromCache_c(resp); int(got) != want {
+            t.Errorf("request %d: from cache %d, want %d", i, got, want)
+        }
+        if status := HioRespStatus_c(resp); status != 200 {
+            t.Errorf("request %d: status %d", i, status)
+        }
+        HioRespFree_c(resp)
+    }
+    if hits != 1 {
+        t.Fatalf("server saw %d requests", hits)
+    }
+}
+
+// TestRetryAttempts checks that a request which succeeds on its third try
+// reports three attempts.
+func TestRetryAttempts(t *testing.T) {
+    var calls int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if atomic.AddInt32(&calls, 1) <= 2 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer srv.Close()
+
+    req, _ := http.NewRequest("GET", srv.URL, nil)
+    resp, attempts, err := fetchWithRetry(sharedClient, req, 5, time.Millisecond)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if attempts != 3 || resp.status != 200 || string(resp.body) != "ok" {
+        t.Fatalf("attempts %d, status %d, body %q", attempts, resp.status, resp.body)
+    }
+}
+
+// TestDecodeText checks charsets well beyond Latin-1 and UTF-16.
+func TestDecodeText(t *testing.T) {
+    cases := []struct {
+        charset string
+        body    []byte
+        want    string
+    }{
+        {"Shift_JIS", []byte{0x93, 0xfa, 0x96, 0x7b}, "日本"},
+        {"euc-jp", []byte{0xc6, 0xfc, 0xcb, 0xdc}, "日本"},
+        {"gbk", []byte{0xd6, 0xd0, 0xce, 0xc4}, "中文"},
+        {"gb2312", []byte{0xd6, 0xd0, 0xce, 0xc4}, "中文"},
+        {"big5", []byte{0xa4, 0xa4, 0xa4, 0xe5}, "中文"},
+        {"euc-kr", []byte{0xc7, 0xd1, 0xb1, 0xb9}, "한국"},
+        {"windows-1251", []byte{0xcf, 0xf0, 0xe8, 0xe2, 0xe5, 0xf2}, "Привет"},
+        {"koi8-r", []byte{0xf0, 0xd2, 0xc9, 0xd7, 0xc5, 0xd4}, "Привет"},
+        {"iso-8859-2", []byte{0xb3, 0xf3, 0x64, 0xbc}, "łódź"},
+        {"iso-8859-1", []byte{'c', 0xe9, 0x80}, "cé€"},
+        {"ibm437", []byte{0x82}, "é"},
+        {"iso-8859-1", []byte{0xff, 0xfe, 'h', 0, 'i', 0}, "hi"},
+    }
+    for _, c := range cases {
+        got, err := decodeText(c.body, c.charset)
+        if err != nil || got != c.want {
+            t.Errorf("%s: got %q, %v; want %q", c.charset, got, err, c.want)
+        }
+    }
+    if _, err := decodeText([]byte("x"), "x-no-such-charset"); err == nil {
+        t.Error("unknown charset accepted")
+    }
+}
\ No newline at end of file
@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import (
+    "errors"
+    "syscall"
+)
+
+// wsaeConnRefused is WSAECONNREFUSED. On Windows syscall.ECONNREFUSED is
+// an errno Go invented, which the errors Winsock returns never match.
+const wsaeConnRefused syscall.Errno = 10061
+
+// isConnRefused reports whether err means nothing was listening on the
+// other end.
+func isConnRefused(err error) bool {
+    return errors.Is(err, wsaeConnRefused) || errors.Is(err, syscall.ECONNREFUSED)
+}
\ No newline at end of file